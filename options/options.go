@@ -13,6 +13,10 @@ const (
 	DefaultWinRMPort          = 5986
 	DefaultWinRMTimeout       = "30s"
 	DefaultWinRMAllowInsecure = false
+
+	DefaultDynamicVHDOvercommitRatio = 1.0
+
+	DefaultStateDir = "/var/lib/kubelet/plugins/hyperv.csi.k8s.io/state"
 )
 
 type Options struct {
@@ -28,6 +32,34 @@ type Options struct {
 	// KubernetesClusterID is the ID of the kubernetes cluster.
 	KubernetesClusterID string
 
+	// ExtraTags is a map of tags that will be attached to each dynamically provisioned
+	// volume. Values may be templates evaluated against the PVC and PV of the volume.
+	ExtraTags map[string]string
+
+	// WarnOnInvalidTag indicates whether to log invalid tags instead of failing
+	WarnOnInvalidTag bool
+
+	// HyperVHosts maps the names of the Hyper-V hosts the controller manages volumes on to their
+	// WinRM address. The names must match the host names Hyper-V reports to the virtual machines.
+	HyperVHosts map[string]string
+
+	// DynamicVHDOvercommitRatio is the ratio of the free space of the Hyper-V host reported as
+	// capacity for dynamic and differencing VHDs, which only take the space that is written.
+	DynamicVHDOvercommitRatio float64
+
+	// VHDBasePath is the directory on the Hyper-V host where volumes are created,
+	// unless a StorageClass overrides it.
+	VHDBasePath string
+
+	// ManagedVHDPaths are the directories on the Hyper-V host, besides VHDBasePath, where
+	// StorageClasses may create volumes. The driver only deletes volumes in managed paths.
+	ManagedVHDPaths []string
+
+	// DeleteUntaggedLegacyVolumes lets volumes with Windows path IDs, created before versioned IDs
+	// and tags were introduced, be deleted without the lifecycle tag of the cluster if their VHD
+	// has no tags at all.
+	DeleteUntaggedLegacyVolumes bool
+
 	// WinRMUser is the username for WinRM connection
 	WinRMUser string
 
@@ -51,6 +83,13 @@ type Options struct {
 
 	// WindowsHostProcess indicates whether the driver is running in a Windows privileged container
 	WindowsHostProcess bool
+
+	// LegacyXFSProgs formats XFS volumes without the features that kernels before 5.10 cannot mount
+	LegacyXFSProgs bool
+
+	// StateDir is the directory on the node where the driver keeps the state of staged volumes
+	// across restarts
+	StateDir string
 }
 
 func (o *Options) AddFlags(f *flag.FlagSet) {
@@ -65,8 +104,20 @@ func (o *Options) AddFlags(f *flag.FlagSet) {
 	f.StringVar(&o.WinRMTimeout, "winrm-timeout", DefaultWinRMTimeout, "Timeout for WinRM connection")
 	f.BoolVar(&o.WinRMAllowInsecure, "winrm-allow-insecure", DefaultWinRMAllowInsecure, "Indicates whether to allow insecure WinRM connections")
 
+	if o.Mode == mode.AllMode || o.Mode == mode.ControllerMode {
+		f.StringToStringVar(&o.ExtraTags, "extra-tags", nil, "Extra tags to attach to each dynamically provisioned volume. It is a comma separated list of key value pairs like '<key1>=<value1>,<key2>=<value2>'")
+		f.BoolVar(&o.WarnOnInvalidTag, "warn-on-invalid-tag", false, "To warn on invalid tags, instead of returning an error")
+		f.StringToStringVar(&o.HyperVHosts, "hyperv-hosts", nil, "Named Hyper-V hosts to manage volumes on. It is a comma separated list of name and WinRM address pairs like '<name1>=<host1>[:<port1>],<name2>=<host2>[:<port2>]'. The default is the single host given by --winrm-host")
+		f.Float64Var(&o.DynamicVHDOvercommitRatio, "dynamic-vhd-overcommit-ratio", DefaultDynamicVHDOvercommitRatio, "Ratio of the free space of the Hyper-V host reported as capacity for dynamic and differencing VHDs. It must be at least 1")
+		f.StringVar(&o.VHDBasePath, "vhd-base-path", "", "Directory on the Hyper-V host where volumes are created. The default is the Hyper-V default, C:\\ProgramData\\Microsoft\\Windows\\Virtual Hard Disks")
+		f.StringSliceVar(&o.ManagedVHDPaths, "managed-vhd-paths", nil, "Comma separated list of directories on the Hyper-V host, besides the VHD base path, where StorageClasses may create volumes with the storagePath parameter. Volumes outside of these directories are never deleted")
+		f.BoolVar(&o.DeleteUntaggedLegacyVolumes, "delete-untagged-legacy-volumes", false, "Delete volumes with Windows path volume IDs, created before volume IDs were versioned, whose VHD has no tags, even without the lifecycle tag of the cluster given by --kubernetes-cluster-id")
+	}
+
 	if o.Mode == mode.AllMode || o.Mode == mode.NodeMode {
 		f.BoolVar(&o.WindowsHostProcess, "windows-host-process", false, "ALPHA: Indicates whether the driver is running in a Windows privileged container")
+		f.StringVar(&o.StateDir, "state-dir", DefaultStateDir, "Directory on the node where the driver keeps the state of staged volumes across restarts")
+		f.BoolVar(&o.LegacyXFSProgs, "legacy-xfs", false, "Format XFS volumes with bigtime=0,inobtcount=0,reflink=0, so that they can be mounted on nodes with Linux kernels before 5.10. Such volumes cannot use reflinks and have timestamps limited to 2038")
 	}
 }
 
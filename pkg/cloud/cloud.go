@@ -2,12 +2,20 @@ package cloud
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/nhduc2001kt/hyperv-csi-driver/options"
 	"github.com/nhduc2001kt/hyperv-csi-driver/pkg/hyperv"
-	"github.com/nhduc2001kt/hyperv-csi-driver/pkg/hyperv/hypervwinrmimpl"
 	"github.com/nhduc2001kt/hyperv-csi-driver/pkg/util"
+	"github.com/nhduc2001kt/hyperv-csi-driver/pkg/winrm"
 	"k8s.io/klog/v2"
 )
 
@@ -18,11 +26,60 @@ const (
 
 	// DefaultVHDBasePath represents the default VHD base path
 	DefaultVHDBasePath = "C:\\ProgramData\\Microsoft\\Windows\\Virtual Hard Disks"
+
+	// SnapshotsDirectory represents the directory, next to the source VHD, that holds its snapshots
+	SnapshotsDirectory = "Snapshots"
+)
+
+// Tags holding the storage QoS of a VHD, applied to the VM hard disk drives it is attached as.
+const (
+	// MaximumIopsTag represents the tag of the maximum normalized IOPS of a VHD.
+	MaximumIopsTag = "hyperv.csi.k8s.io/maximum-iops"
+
+	// MinimumIopsTag represents the tag of the minimum normalized IOPS of a VHD.
+	MinimumIopsTag = "hyperv.csi.k8s.io/minimum-iops"
+
+	// QosPolicyIDTag represents the tag of the ID of the storage QoS policy of a VHD.
+	QosPolicyIDTag = "hyperv.csi.k8s.io/qos-policy-id"
+)
+
+// ChildrenTag represents the tag listing, as a JSON array, the paths of the differencing VHDs
+// created from a parent VHD.
+const ChildrenTag = "hyperv.csi.k8s.io/children"
+
+var (
+	// ErrNotFound is returned when a resource is not found.
+	ErrNotFound = errors.New("resource was not found")
+
+	// ErrAlreadyExists is returned when a resource is already existent.
+	ErrAlreadyExists = errors.New("resource already exists")
+
+	// ErrIdempotentParameterMismatch is returned when a resource exists with parameters other than the requested ones.
+	ErrIdempotentParameterMismatch = errors.New("parameters on this idempotent request are inconsistent with parameters used in previous request(s)")
+
+	// ErrInUse is returned when a resource is still referenced or locked by another resource.
+	ErrInUse = errors.New("resource is in use")
+
+	// ErrInvalidParameter is returned when the Hyper-V host rejects a parameter.
+	ErrInvalidParameter = errors.New("invalid parameter")
+
+	// ErrAccessDenied is returned when the Hyper-V host denies an operation.
+	ErrAccessDenied = errors.New("access denied")
+
+	// ErrTimeout is returned when an operation on the Hyper-V host times out.
+	ErrTimeout = errors.New("operation timed out")
 )
 
 // CreateHyperVVHDInput represents the input for CreateHyperVVHD.
 type CreateHyperVVHDInput struct {
-	Name               string
+	Name string
+	// Directory overrides the VHD base path of the cloud
+	Directory string
+	// Hosts are the names of the Hyper-V hosts the VHD may be created on, by order of preference
+	Hosts []string
+	// SourceHost is the name of the Hyper-V host holding Source or ParentPath, it is looked up if
+	// empty
+	SourceHost         string
 	Source             string
 	SourceVm           string
 	SourceDisk         int
@@ -33,10 +90,21 @@ type CreateHyperVVHDInput struct {
 	BlockSize          uint32
 	LogicalSectorSize  uint32
 	PhysicalSectorSize uint32
+	QoS                HyperVVHDQoS
+	Tags               map[string]string
+}
+
+// HyperVVHDQoS represents the storage QoS of a VHD. Zero values mean no limit and no policy.
+type HyperVVHDQoS struct {
+	MaximumIops uint64
+	MinimumIops uint64
+	QosPolicyID string
 }
 
 // GetHyperVVHDInput represents the input for GetHyperVVHD.
 type GetHyperVVHDInput struct {
+	// Host is the name of the Hyper-V host holding the VHD, it is looked up if empty
+	Host string
 	Path string
 }
 
@@ -50,16 +118,100 @@ type GetHyperVVHDOutput struct {
 	BlockSize          uint32
 	LogicalSectorSize  uint32
 	PhysicalSectorSize uint32
+	QoS                HyperVVHDQoS
+	Tags               map[string]string
+}
+
+// HyperVVHD represents a VHD managed by the driver and the virtual machines it is attached to.
+type HyperVVHD struct {
+	Path       string
+	Host       string
+	Size       uint64
+	ParentPath string
+	// Attached is true if the VHD is mounted, by a virtual machine or by the host itself
+	Attached bool
+	VmIDs    []string
+	// Missing is true if virtual machines reference the VHD but its file does not exist
+	Missing bool
+	// ParentChainValid is false if a parent of a differencing VHD is missing or was modified
+	ParentChainValid bool
+}
+
+// ListHyperVVHDsInput represents the input for ListHyperVVHDs.
+// If Path is set, only the VHD at Path is listed, even outside of the VHD base path.
+type ListHyperVVHDsInput struct {
+	// Host is the name of the Hyper-V host holding the VHD, it is looked up if empty
+	Host string
+	Path string
+}
+
+// ListHyperVVHDsOutput represents the output for ListHyperVVHDs.
+type ListHyperVVHDsOutput struct {
+	VHDs []HyperVVHD
 }
 
 // CreateHyperVVHDOutput represents the output for CreateHyperVVHD.
 type CreateHyperVVHDOutput struct {
 	Path string
+	// Host is the name of the Hyper-V host holding the VHD, empty if hosts are not named
+	Host string
+	Size uint64
+	// FileSize is the space the VHD takes on the host, which is lower than Size for dynamic disks
+	FileSize uint64
+}
+
+// ExpandHyperVVHDInput represents the input for ExpandHyperVVHD.
+type ExpandHyperVVHDInput struct {
+	// Host is the name of the Hyper-V host holding the VHD, it is looked up if empty
+	Host string
+	Path string
+	Size uint64
+}
+
+// ExpandHyperVVHDOutput represents the output for ExpandHyperVVHD.
+type ExpandHyperVVHDOutput struct {
+	Size uint64
+}
+
+// GetHyperVCapacityInput represents the input for GetHyperVCapacity.
+type GetHyperVCapacityInput struct {
+	// Host is the name of the Hyper-V host, every host is considered if empty
+	Host string
+	// Directory overrides the VHD base path of the cloud
+	Directory string
+}
+
+// GetHyperVCapacityOutput represents the output for GetHyperVCapacity.
+type GetHyperVCapacityOutput struct {
+	TotalBytes     uint64
+	AvailableBytes uint64
+}
+
+// ModifyHyperVVHDInput represents the input for ModifyHyperVVHD.
+type ModifyHyperVVHDInput struct {
+	// Host is the name of the Hyper-V host holding the VHD, it is looked up if empty
+	Host string
+	Path string
+	// The storage QoS values to set, including zero values that remove a limit or a policy. Values
+	// that are nil keep their current value.
+	MaximumIops *uint64
+	MinimumIops *uint64
+	QosPolicyID *string
 }
 
+// ModifyHyperVVHDOutput represents the output for ModifyHyperVVHD.
+type ModifyHyperVVHDOutput struct{}
+
 // DeleteHyperVVHDInput represents the input for DeleteHyperVVHD.
 type DeleteHyperVVHDInput struct {
+	// Host is the name of the Hyper-V host holding the VHD, it is looked up if empty
+	Host string
 	Path string
+	// OwnerTags are tags the VHD must have to be deleted, like the lifecycle tag of the cluster
+	OwnerTags map[string]string
+	// AllowUntagged lets VHDs without any tags be deleted despite OwnerTags, like the volumes
+	// created before tags were introduced
+	AllowUntagged bool
 }
 
 // DeleteHyperVVHDOutput represents the output for DeleteHyperVVHD.
@@ -67,19 +219,29 @@ type DeleteHyperVVHDOutput struct{}
 
 // AttachHyperVVHDInput represents the input for AttachHyperVVHD.
 type AttachHyperVVHDInput struct {
-	VmID    string
+	VmID string
+	// Host is the name of the Hyper-V host holding the VHD, it is looked up if empty
+	Host    string
 	VHDPath string
+	// SupportPersistentReservations allows the VHD to be attached to several VMs at once
+	SupportPersistentReservations bool
+	CacheAttributes               hyperv.CacheAttributes
 }
 
 // AttachHyperVVHDOutput represents the output for AttachHyperVVHD.
 type AttachHyperVVHDOutput struct {
 	ControllerNumber   int32
 	ControllerLocation int32
+	CacheAttributes    hyperv.CacheAttributes
+	// DiskIdentifier is the SCSI identifier of the VHD, which nodes find the disk by
+	DiskIdentifier string
 }
 
 // DetachHyperVVHDInput represents the input for DetachHyperVVHD.
 type DetachHyperVVHDInput struct {
-	VmID    string
+	VmID string
+	// Host is the name of the Hyper-V host holding the VHD, it is looked up if empty
+	Host    string
 	VHDPath string
 }
 
@@ -89,12 +251,67 @@ type DetachHyperVVHDOutput struct {
 	ControllerLocation int32
 }
 
+// HyperVVHDSnapshot represents a point-in-time copy of a VHD.
+type HyperVVHDSnapshot struct {
+	Path string
+	// Host is the name of the Hyper-V host holding the snapshot, empty if hosts are not named
+	Host         string
+	SourcePath   string
+	Size         uint64
+	CreationTime time.Time
+}
+
+// CreateHyperVVHDSnapshotInput represents the input for CreateHyperVVHDSnapshot.
+type CreateHyperVVHDSnapshotInput struct {
+	Name string
+	// SourceHost is the name of the Hyper-V host holding the source, it is looked up if empty
+	SourceHost string
+	SourcePath string
+}
+
+// CreateHyperVVHDSnapshotOutput represents the output for CreateHyperVVHDSnapshot.
+type CreateHyperVVHDSnapshotOutput struct {
+	HyperVVHDSnapshot
+}
+
+// ListHyperVVHDSnapshotsInput represents the input for ListHyperVVHDSnapshots.
+// At most one of SnapshotPath and SourcePath is used to filter the result.
+type ListHyperVVHDSnapshotsInput struct {
+	// Host is the name of the Hyper-V host holding SnapshotPath or SourcePath, it is looked up if
+	// empty
+	Host         string
+	SnapshotPath string
+	SourcePath   string
+}
+
+// ListHyperVVHDSnapshotsOutput represents the output for ListHyperVVHDSnapshots.
+type ListHyperVVHDSnapshotsOutput struct {
+	Snapshots []HyperVVHDSnapshot
+}
+
+// DeleteHyperVVHDSnapshotInput represents the input for DeleteHyperVVHDSnapshot.
+type DeleteHyperVVHDSnapshotInput struct {
+	// Host is the name of the Hyper-V host holding the snapshot, it is looked up if empty
+	Host string
+	Path string
+}
+
+// DeleteHyperVVHDSnapshotOutput represents the output for DeleteHyperVVHDSnapshot.
+type DeleteHyperVVHDSnapshotOutput struct{}
+
 type Cloud interface {
 	GetHyperVVHD(context.Context, *GetHyperVVHDInput) (*GetHyperVVHDOutput, error)
+	ListHyperVVHDs(context.Context, *ListHyperVVHDsInput) (*ListHyperVVHDsOutput, error)
 	CreateHyperVVHD(context.Context, *CreateHyperVVHDInput) (*CreateHyperVVHDOutput, error)
+	ExpandHyperVVHD(context.Context, *ExpandHyperVVHDInput) (*ExpandHyperVVHDOutput, error)
+	ModifyHyperVVHD(context.Context, *ModifyHyperVVHDInput) (*ModifyHyperVVHDOutput, error)
 	DeleteHyperVVHD(context.Context, *DeleteHyperVVHDInput) (*DeleteHyperVVHDOutput, error)
+	GetHyperVCapacity(context.Context, *GetHyperVCapacityInput) (*GetHyperVCapacityOutput, error)
 	AttachHyperVVHD(context.Context, *AttachHyperVVHDInput) (*AttachHyperVVHDOutput, error)
 	DetachHyperVVHD(context.Context, *DetachHyperVVHDInput) (*DetachHyperVVHDOutput, error)
+	CreateHyperVVHDSnapshot(context.Context, *CreateHyperVVHDSnapshotInput) (*CreateHyperVVHDSnapshotOutput, error)
+	ListHyperVVHDSnapshots(context.Context, *ListHyperVVHDSnapshotsInput) (*ListHyperVVHDSnapshotsOutput, error)
+	DeleteHyperVVHDSnapshot(context.Context, *DeleteHyperVVHDSnapshotInput) (*DeleteHyperVVHDSnapshotOutput, error)
 }
 
 type CloudConfig interface {
@@ -103,20 +320,19 @@ type CloudConfig interface {
 // NewCloud returns a new instance of Docker client
 // It panics if session is invalid.
 func NewCloud(opts *options.Options) (Cloud, error) {
-	hypervClient, err := hypervwinrmimpl.NewClient(opts)
-	if err != nil {
-		return nil, err
-	}
-
-	return &cloud{
-		hypervClient: hypervClient,
-		vhdBasePath:  DefaultVHDBasePath,
-	}, nil
+	return newHostRegistry(opts)
 }
 
+// cloud manages the VHDs of a single Hyper-V host.
 type cloud struct {
+	name         string
 	hypervClient hyperv.HyperVClient
 	vhdBasePath  string
+	// managedPaths are the directories besides vhdBasePath where volumes may be created
+	managedPaths []string
+
+	// childrenMux serializes the updates of the children tags of parent VHDs
+	childrenMux sync.Mutex
 }
 
 func (c *cloud) GetHyperVVHD(ctx context.Context, i *GetHyperVVHDInput) (*GetHyperVVHDOutput, error) {
@@ -126,10 +342,19 @@ func (c *cloud) GetHyperVVHD(ctx context.Context, i *GetHyperVVHDInput) (*GetHyp
 
 	vhd, err := client.GetVHD(ctx, i.Path)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
 	}
 	if vhd.Path != i.Path {
-		return nil, fmt.Errorf("VHD not found: %s", i.Path)
+		return nil, fmt.Errorf("%w: VHD %s", ErrNotFound, i.Path)
+	}
+
+	tags, err := client.GetVHDTags(ctx, i.Path)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	qos, err := qosFromTags(tags)
+	if err != nil {
+		return nil, err
 	}
 
 	return &GetHyperVVHDOutput{
@@ -141,70 +366,605 @@ func (c *cloud) GetHyperVVHD(ctx context.Context, i *GetHyperVVHDInput) (*GetHyp
 		BlockSize:          vhd.BlockSize,
 		LogicalSectorSize:  vhd.LogicalSectorSize,
 		PhysicalSectorSize: vhd.PhysicalSectorSize,
+		QoS:                qos,
+		Tags:               tags,
 	}, nil
 }
 
+func (c *cloud) ListHyperVVHDs(ctx context.Context, i *ListHyperVVHDsInput) (*ListHyperVVHDsOutput, error) {
+	klog.V(4).InfoS("ListHyperVVHDs: called", "args", util.SanitizeRequest(i))
+
+	client := c.hypervClient
+
+	// Volumes are in the base path, or in a managed path set as storage path
+	directories := c.managedRoots()
+	if i.Path != "" {
+		directories = []string{util.DirWinPath(i.Path)}
+	}
+	var vhds []hyperv.AttachedVHD
+	for _, directory := range directories {
+		directoryVHDs, err := client.GetVHDs(ctx, directory, i.Path)
+		if err != nil {
+			return nil, wrapError(err)
+		}
+		vhds = append(vhds, directoryVHDs...)
+	}
+
+	output := &ListHyperVVHDsOutput{
+		VHDs: make([]HyperVVHD, 0, len(vhds)),
+	}
+	seen := map[string]bool{}
+	for _, vhd := range vhds {
+		key := strings.ToLower(vhd.Path)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		output.VHDs = append(output.VHDs, HyperVVHD{
+			Path:             vhd.Path,
+			Host:             c.name,
+			Size:             vhd.Size,
+			ParentPath:       vhd.ParentPath,
+			Attached:         vhd.Attached,
+			VmIDs:            vhd.VMIDs,
+			Missing:          vhd.Missing,
+			ParentChainValid: vhd.ParentChainValid,
+		})
+	}
+
+	return output, nil
+}
+
 func (c *cloud) CreateHyperVVHD(ctx context.Context, i *CreateHyperVVHDInput) (*CreateHyperVVHDOutput, error) {
 	klog.V(4).InfoS("CreateHyperVVHD: called", "args", util.SanitizeRequest(i))
 
 	client := c.hypervClient
+
+	directory := c.vhdBasePath
+	if i.Directory != "" {
+		directory = i.Directory
+	}
+	// Volumes outside of the managed paths could not be deleted, and other paths never reach the host
+	if !c.isManagedDirectory(directory) {
+		return nil, fmt.Errorf("%w: directory %s is not a managed path", ErrInvalidParameter, directory)
+	}
+	exists, err := client.DirectoryExists(ctx, directory)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: directory %s does not exist", ErrInvalidParameter, directory)
+	}
+
 	vhdFile := fmt.Sprintf("%s%s", i.Name, hyperv.VHDFormatExtension[i.Format])
-	vhdPath := util.JoinWinPath(c.vhdBasePath, vhdFile)
-	err := client.CreateOrUpdateVHD(
-		ctx,
-		vhdPath,
-		i.Source,
-		i.SourceVm,
-		i.SourceDisk,
-		i.Type,
-		i.ParentPath,
-		i.Size,
-		i.BlockSize,
-		i.LogicalSectorSize,
-		i.PhysicalSectorSize,
-	)
+	vhdPath := util.JoinWinPath(directory, vhdFile)
+
+	// A volume of the same name in another format was created by an earlier request
+	for format, extension := range hyperv.VHDFormatExtension {
+		if format == i.Format || extension == "" {
+			continue
+		}
+		exists, err := client.VHDExists(ctx, util.JoinWinPath(directory, i.Name+extension))
+		if err != nil {
+			return nil, wrapError(err)
+		}
+		if exists.Exists {
+			return nil, fmt.Errorf("%w: VHD %s exists with format %s", ErrIdempotentParameterMismatch, i.Name, format)
+		}
+	}
+
+	vhd, err := client.GetVHD(ctx, vhdPath)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
+	}
+	if vhd.Path == vhdPath {
+		if err := checkIdempotentParameters(&vhd, i); err != nil {
+			return nil, err
+		}
+		klog.V(4).InfoS("CreateHyperVVHD: VHD already exists", "path", vhdPath)
+	}
+
+	// Children are registered before they are created, so that their parent cannot be deleted meanwhile
+	if i.ParentPath != "" {
+		if err := c.addChild(ctx, i.ParentPath, vhdPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if vhd.Path != vhdPath {
+		err = client.CreateOrUpdateVHD(
+			ctx,
+			vhdPath,
+			i.Source,
+			i.SourceVm,
+			i.SourceDisk,
+			i.Type,
+			i.ParentPath,
+			i.Size,
+			i.BlockSize,
+			i.LogicalSectorSize,
+			i.PhysicalSectorSize,
+		)
+		if err != nil {
+			return nil, wrapError(err)
+		}
+
+		vhd, err = client.GetVHD(ctx, vhdPath)
+		if err != nil {
+			return nil, wrapError(err)
+		}
+		if vhd.Path != vhdPath {
+			return nil, fmt.Errorf("%w: VHD %s", ErrNotFound, vhdPath)
+		}
+	}
+
+	// A retried request keeps the tags added since the VHD was tagged, e.g. the children of a parent
+	// and the QoS set by ModifyHyperVVHD, so the QoS of the request is only set on the first write
+	tags, err := client.GetVHDTags(ctx, vhdPath)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	tagged := len(tags) > 0
+	for k, v := range i.Tags {
+		tags[k] = v
+	}
+	if !tagged {
+		setQoSTags(tags, i.QoS)
+	}
+	if len(tags) > 0 {
+		if err := client.SetVHDTags(ctx, vhdPath, tags); err != nil {
+			return nil, wrapError(err)
+		}
+	}
+
+	// Copies and differencing children inherit the size of their source, grow them to the requested size
+	if (i.Source != "" || i.ParentPath != "") && vhd.Size < i.Size {
+		if err := client.ResizeVHD(ctx, vhdPath, i.Size); err != nil {
+			return nil, wrapError(err)
+		}
+		vhd, err = client.GetVHD(ctx, vhdPath)
+		if err != nil {
+			return nil, wrapError(err)
+		}
 	}
 
 	return &CreateHyperVVHDOutput{
-		Path: vhdPath,
+		Path:     vhdPath,
+		Host:     c.name,
+		Size:     vhd.Size,
+		FileSize: vhd.FileSize,
+	}, nil
+}
+
+// checkIdempotentParameters returns ErrIdempotentParameterMismatch if an existing VHD does not match the input
+// it would be created with.
+func checkIdempotentParameters(vhd *hyperv.VHD, i *CreateHyperVVHDInput) error {
+	isClone := i.Source != "" || i.ParentPath != ""
+
+	switch {
+	case vhd.VHDFormat != i.Format:
+		return fmt.Errorf("%w: VHD %s has format %s instead of %s", ErrIdempotentParameterMismatch, vhd.Path, vhd.VHDFormat, i.Format)
+	case vhd.VHDType != i.Type:
+		return fmt.Errorf("%w: VHD %s has type %s instead of %s", ErrIdempotentParameterMismatch, vhd.Path, vhd.VHDType, i.Type)
+	// Clones are grown after they are copied, a smaller clone was interrupted before its resize
+	case vhd.Size > i.Size, !isClone && vhd.Size != i.Size:
+		return fmt.Errorf("%w: VHD %s has size %d instead of %d", ErrIdempotentParameterMismatch, vhd.Path, vhd.Size, i.Size)
+	case i.BlockSize > 0 && vhd.BlockSize != i.BlockSize:
+		return fmt.Errorf("%w: VHD %s has block size %d instead of %d", ErrIdempotentParameterMismatch, vhd.Path, vhd.BlockSize, i.BlockSize)
+	case i.LogicalSectorSize > 0 && vhd.LogicalSectorSize != i.LogicalSectorSize:
+		return fmt.Errorf("%w: VHD %s has logical sector size %d instead of %d", ErrIdempotentParameterMismatch, vhd.Path, vhd.LogicalSectorSize, i.LogicalSectorSize)
+	case i.PhysicalSectorSize > 0 && vhd.PhysicalSectorSize != i.PhysicalSectorSize:
+		return fmt.Errorf("%w: VHD %s has physical sector size %d instead of %d", ErrIdempotentParameterMismatch, vhd.Path, vhd.PhysicalSectorSize, i.PhysicalSectorSize)
+	case i.ParentPath != "" && !strings.EqualFold(vhd.ParentPath, i.ParentPath):
+		return fmt.Errorf("%w: VHD %s has parent %q instead of %q", ErrIdempotentParameterMismatch, vhd.Path, vhd.ParentPath, i.ParentPath)
+	}
+
+	return nil
+}
+
+func (c *cloud) ExpandHyperVVHD(ctx context.Context, i *ExpandHyperVVHDInput) (*ExpandHyperVVHDOutput, error) {
+	klog.V(4).InfoS("ExpandHyperVVHD: called", "args", util.SanitizeRequest(i))
+
+	client := c.hypervClient
+
+	vhd, err := client.GetVHD(ctx, i.Path)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	if vhd.Path != i.Path {
+		return nil, fmt.Errorf("%w: VHD %s", ErrNotFound, i.Path)
+	}
+
+	// VHDs are never shrunk, a smaller request is already satisfied
+	if vhd.Size >= i.Size {
+		klog.V(4).InfoS("ExpandHyperVVHD: VHD is already large enough", "path", i.Path, "size", vhd.Size)
+		return &ExpandHyperVVHDOutput{
+			Size: vhd.Size,
+		}, nil
+	}
+
+	if err := client.ResizeVHD(ctx, i.Path, i.Size); err != nil {
+		return nil, wrapError(err)
+	}
+	// Hyper-V aligns the size of a VHD to its blocks and sectors
+	vhd, err = client.GetVHD(ctx, i.Path)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return &ExpandHyperVVHDOutput{
+		Size: vhd.Size,
 	}, nil
 }
 
+func (c *cloud) ModifyHyperVVHD(ctx context.Context, i *ModifyHyperVVHDInput) (*ModifyHyperVVHDOutput, error) {
+	klog.V(4).InfoS("ModifyHyperVVHD: called", "args", util.SanitizeRequest(i))
+
+	client := c.hypervClient
+
+	vhds, err := client.GetVHDs(ctx, util.DirWinPath(i.Path), i.Path)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	if len(vhds) == 0 || vhds[0].Missing {
+		return nil, fmt.Errorf("%w: VHD %s", ErrNotFound, i.Path)
+	}
+
+	// The tags are updated first, so that a VM the VHD is attached to meanwhile gets the new QoS
+	tags, err := client.GetVHDTags(ctx, i.Path)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	qos, err := qosFromTags(tags)
+	if err != nil {
+		return nil, err
+	}
+	if i.MaximumIops != nil {
+		qos.MaximumIops = *i.MaximumIops
+	}
+	if i.MinimumIops != nil {
+		qos.MinimumIops = *i.MinimumIops
+	}
+	if i.QosPolicyID != nil {
+		qos.QosPolicyID = *i.QosPolicyID
+	}
+	setQoSTags(tags, qos)
+	if err := client.SetVHDTags(ctx, i.Path, tags); err != nil {
+		return nil, wrapError(err)
+	}
+
+	// Only the values of the request are set, so that a limit it removes is removed from the VMs too
+	for _, vmID := range vhds[0].VMIDs {
+		err := client.SetVMHardDiskDriveQoS(ctx, vmID, i.Path, i.MaximumIops, i.MinimumIops, i.QosPolicyID)
+		if err != nil {
+			return nil, wrapError(err)
+		}
+	}
+
+	return &ModifyHyperVVHDOutput{}, nil
+}
+
 func (c *cloud) DeleteHyperVVHD(ctx context.Context, i *DeleteHyperVVHDInput) (*DeleteHyperVVHDOutput, error) {
 	klog.V(4).InfoS("DeleteHyperVVHD: called", "args", util.SanitizeRequest(i))
 
 	client := c.hypervClient
-	err := client.DeleteVHD(ctx, i.Path)
+
+	if !c.isManagedPath(i.Path) {
+		return nil, fmt.Errorf("%w: VHD %s is not in a managed path", ErrInvalidParameter, i.Path)
+	}
+
+	vhd, err := client.GetVHD(ctx, i.Path)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
+	}
+	// The files left by an interrupted deletion are removed without further checks
+	exists := vhd.Path == i.Path
+	if exists {
+		if vhd.Attached {
+			return nil, fmt.Errorf("%w: VHD %s is attached", ErrInUse, i.Path)
+		}
+		if len(i.OwnerTags) > 0 {
+			tags, err := client.GetVHDTags(ctx, i.Path)
+			if err != nil {
+				return nil, wrapError(err)
+			}
+			// Even the children tag is written by a driver that tags the VHDs it creates
+			if len(tags) == 0 && i.AllowUntagged {
+				klog.InfoS("DeleteHyperVVHD: deleting VHD without tags as owned", "path", i.Path)
+			} else {
+				for key, value := range i.OwnerTags {
+					if tags[key] != value {
+						return nil, fmt.Errorf("%w: VHD %s is not owned by this cluster, tag %s is %q", ErrAccessDenied, i.Path, key, tags[key])
+					}
+				}
+			}
+		}
+		if err := c.checkNoChildren(ctx, i.Path); err != nil {
+			return nil, err
+		}
+	}
+
+	err = client.DeleteVHD(ctx, i.Path)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	if exists && vhd.ParentPath != "" {
+		if err := c.removeChild(ctx, vhd.ParentPath, i.Path); err != nil {
+			// A child that no longer exists is dropped by the next check of its parent
+			klog.InfoS("DeleteHyperVVHD: could not unregister VHD from its parent", "path", i.Path, "parentPath", vhd.ParentPath, "err", err)
+		}
 	}
 
 	return &DeleteHyperVVHDOutput{}, nil
 }
 
+// managedRoots returns the base path and the managed paths, without duplicates.
+func (c *cloud) managedRoots() []string {
+	roots := []string{c.vhdBasePath}
+	for _, root := range c.managedPaths {
+		if !slices.ContainsFunc(roots, func(r string) bool {
+			return strings.EqualFold(strings.TrimRight(r, "\\"), strings.TrimRight(root, "\\"))
+		}) {
+			roots = append(roots, root)
+		}
+	}
+	return roots
+}
+
+// isManagedPath reports whether path is inside the base path or a managed path.
+func (c *cloud) isManagedPath(path string) bool {
+	if util.IsWinPathWithin(path, c.vhdBasePath) {
+		return true
+	}
+	for _, root := range c.managedPaths {
+		if util.IsWinPathWithin(path, root) {
+			return true
+		}
+	}
+	return false
+}
+
+// isManagedDirectory reports whether directory is the base path, a managed path or inside one.
+func (c *cloud) isManagedDirectory(directory string) bool {
+	directory = strings.TrimRight(directory, "\\")
+	if strings.EqualFold(directory, strings.TrimRight(c.vhdBasePath, "\\")) {
+		return true
+	}
+	for _, root := range c.managedPaths {
+		if strings.EqualFold(directory, strings.TrimRight(root, "\\")) {
+			return true
+		}
+	}
+	return c.isManagedPath(directory)
+}
+
+// addChild registers a differencing VHD in the children tag of its parent.
+func (c *cloud) addChild(ctx context.Context, parentPath, path string) error {
+	return c.updateChildren(ctx, parentPath, func(children []string) []string {
+		if containsFold(children, path) {
+			return children
+		}
+		return append(children, path)
+	})
+}
+
+// removeChild unregisters a differencing VHD from the children tag of its parent.
+func (c *cloud) removeChild(ctx context.Context, parentPath, path string) error {
+	return c.updateChildren(ctx, parentPath, func(children []string) []string {
+		kept := children[:0]
+		for _, child := range children {
+			if !strings.EqualFold(child, path) {
+				kept = append(kept, child)
+			}
+		}
+		return kept
+	})
+}
+
+func (c *cloud) updateChildren(ctx context.Context, parentPath string, update func([]string) []string) error {
+	c.childrenMux.Lock()
+	defer c.childrenMux.Unlock()
+
+	client := c.hypervClient
+
+	tags, err := client.GetVHDTags(ctx, parentPath)
+	if err != nil {
+		return wrapError(err)
+	}
+	children, err := childrenFromTags(tags)
+	if err != nil {
+		return err
+	}
+
+	children = update(children)
+	if len(children) == 0 {
+		delete(tags, ChildrenTag)
+	} else {
+		value, err := json.Marshal(children)
+		if err != nil {
+			return err
+		}
+		tags[ChildrenTag] = string(value)
+	}
+
+	if err := client.SetVHDTags(ctx, parentPath, tags); err != nil {
+		return wrapError(err)
+	}
+	return nil
+}
+
+// checkNoChildren returns ErrInUse if differencing VHDs registered with the parent still exist.
+func (c *cloud) checkNoChildren(ctx context.Context, parentPath string) error {
+	client := c.hypervClient
+
+	tags, err := client.GetVHDTags(ctx, parentPath)
+	if err != nil {
+		return wrapError(err)
+	}
+	children, err := childrenFromTags(tags)
+	if err != nil {
+		return err
+	}
+
+	for _, child := range children {
+		vhd, err := client.GetVHD(ctx, child)
+		if err != nil {
+			return wrapError(err)
+		}
+		// Children deleted or re-parented outside of the driver are ignored
+		if vhd.Path == child && strings.EqualFold(vhd.ParentPath, parentPath) {
+			return fmt.Errorf("%w: VHD %s is the parent of %s", ErrInUse, parentPath, child)
+		}
+	}
+	return nil
+}
+
+func childrenFromTags(tags map[string]string) ([]string, error) {
+	value, ok := tags[ChildrenTag]
+	if !ok {
+		return nil, nil
+	}
+	var children []string
+	if err := json.Unmarshal([]byte(value), &children); err != nil {
+		return nil, fmt.Errorf("invalid value %q of tag %s: %w", value, ChildrenTag, err)
+	}
+	return children, nil
+}
+
+func (c *cloud) GetHyperVCapacity(ctx context.Context, i *GetHyperVCapacityInput) (*GetHyperVCapacityOutput, error) {
+	klog.V(4).InfoS("GetHyperVCapacity: called", "args", util.SanitizeRequest(i))
+
+	client := c.hypervClient
+
+	directory := c.vhdBasePath
+	if i.Directory != "" {
+		directory = i.Directory
+	}
+	// Other paths never reach the host
+	if !c.isManagedDirectory(directory) {
+		return nil, fmt.Errorf("%w: directory %s is not a managed path", ErrInvalidParameter, directory)
+	}
+	exists, err := client.DirectoryExists(ctx, directory)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: directory %s does not exist", ErrInvalidParameter, directory)
+	}
+
+	volume, err := client.GetVolume(ctx, directory)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return &GetHyperVCapacityOutput{
+		TotalBytes:     volume.Size,
+		AvailableBytes: volume.SizeRemaining,
+	}, nil
+}
+
 func (c *cloud) AttachHyperVVHD(ctx context.Context, i *AttachHyperVVHDInput) (*AttachHyperVVHDOutput, error) {
 	klog.V(4).InfoS("AttachHyperVVHD: called", "args", util.SanitizeRequest(i))
 
 	client := c.hypervClient
 
+	// Only VHDs shared with persistent reservations can be attached to another VM
+	if !i.SupportPersistentReservations {
+		vhds, err := client.GetVHDs(ctx, util.DirWinPath(i.VHDPath), i.VHDPath)
+		if err != nil {
+			return nil, wrapError(err)
+		}
+		for _, vhd := range vhds {
+			for _, vmID := range vhd.VMIDs {
+				if !strings.EqualFold(vmID, i.VmID) {
+					return nil, fmt.Errorf("%w: VHD %s is attached to VM %s", ErrInUse, i.VHDPath, vmID)
+				}
+			}
+		}
+	}
+
+	tags, err := client.GetVHDTags(ctx, i.VHDPath)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	qos, err := qosFromTags(tags)
+	if err != nil {
+		return nil, err
+	}
+
 	res, err := client.AttachVMHardDiskDrive(
 		ctx,
 		i.VmID,
 		hyperv.ControllerTypeSCSI,
 		i.VHDPath,
+		i.SupportPersistentReservations,
+		qos.MaximumIops,
+		qos.MinimumIops,
+		qos.QosPolicyID,
+		i.CacheAttributes,
 	)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
+	}
+
+	vhd, err := client.GetVHD(ctx, i.VHDPath)
+	if err != nil {
+		return nil, wrapError(err)
 	}
 
 	return &AttachHyperVVHDOutput{
 		ControllerNumber:   res.ControllerNumber,
 		ControllerLocation: res.ControllerLocation,
+		CacheAttributes:    res.OverrideCacheAttributes,
+		DiskIdentifier:     vhd.DiskIdentifier,
 	}, nil
 }
 
+// qosFromTags returns the storage QoS kept in the tags of a VHD.
+func qosFromTags(tags map[string]string) (HyperVVHDQoS, error) {
+	var (
+		qos HyperVVHDQoS
+		err error
+	)
+	if v, ok := tags[MaximumIopsTag]; ok {
+		qos.MaximumIops, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return qos, fmt.Errorf("invalid value %q of tag %s: %w", v, MaximumIopsTag, err)
+		}
+	}
+	if v, ok := tags[MinimumIopsTag]; ok {
+		qos.MinimumIops, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return qos, fmt.Errorf("invalid value %q of tag %s: %w", v, MinimumIopsTag, err)
+		}
+	}
+	qos.QosPolicyID = tags[QosPolicyIDTag]
+	return qos, nil
+}
+
+// setQoSTags replaces the storage QoS kept in the tags of a VHD. Unset values are removed.
+func setQoSTags(tags map[string]string, qos HyperVVHDQoS) {
+	delete(tags, MaximumIopsTag)
+	delete(tags, MinimumIopsTag)
+	delete(tags, QosPolicyIDTag)
+	if qos.MaximumIops > 0 {
+		tags[MaximumIopsTag] = strconv.FormatUint(qos.MaximumIops, 10)
+	}
+	if qos.MinimumIops > 0 {
+		tags[MinimumIopsTag] = strconv.FormatUint(qos.MinimumIops, 10)
+	}
+	if qos.QosPolicyID != "" {
+		tags[QosPolicyIDTag] = qos.QosPolicyID
+	}
+}
+
 func (c *cloud) DetachHyperVVHD(ctx context.Context, i *DetachHyperVVHDInput) (*DetachHyperVVHDOutput, error) {
 	klog.V(4).InfoS("DetachHyperVVHD: called", "args", util.SanitizeRequest(i))
 
@@ -212,8 +972,164 @@ func (c *cloud) DetachHyperVVHD(ctx context.Context, i *DetachHyperVVHDInput) (*
 
 	err := client.DetachVMHardDiskDrive(ctx, i.VmID, i.VHDPath)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(err)
 	}
 
 	return &DetachHyperVVHDOutput{}, nil
 }
+
+func (c *cloud) CreateHyperVVHDSnapshot(ctx context.Context, i *CreateHyperVVHDSnapshotInput) (*CreateHyperVVHDSnapshotOutput, error) {
+	klog.V(4).InfoS("CreateHyperVVHDSnapshot: called", "args", util.SanitizeRequest(i))
+
+	client := c.hypervClient
+
+	// The data of a VHD Set is held in other files, which a copy of the .vhds file does not take
+	if strings.HasSuffix(strings.ToLower(i.SourcePath), hyperv.VHDFormatExtension[hyperv.VHDFormatVHDSet]) {
+		return nil, fmt.Errorf("%w: snapshots of VHD Set %s are not supported", ErrInvalidParameter, i.SourcePath)
+	}
+
+	// Snapshot names are unique across all source volumes sharing a snapshot root
+	snapshotsRoot := util.JoinWinPath(util.DirWinPath(i.SourcePath), SnapshotsDirectory)
+	existing, err := client.GetVHDSnapshots(ctx, snapshotsRoot, i.Name)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+	for _, snapshot := range existing {
+		if !strings.EqualFold(snapshot.SourcePath, i.SourcePath) {
+			return nil, fmt.Errorf("%w: snapshot %q was taken from %q", ErrAlreadyExists, i.Name, snapshot.SourcePath)
+		}
+	}
+
+	sourceFile := util.BaseWinPath(i.SourcePath)
+	sourceExt := filepath.Ext(sourceFile)
+	snapshotPath := util.JoinWinPath(snapshotsRoot, strings.TrimSuffix(sourceFile, sourceExt), i.Name+sourceExt)
+
+	snapshot, err := client.CreateVHDSnapshot(ctx, i.SourcePath, snapshotPath)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return &CreateHyperVVHDSnapshotOutput{
+		HyperVVHDSnapshot: c.newHyperVVHDSnapshot(snapshot),
+	}, nil
+}
+
+func (c *cloud) ListHyperVVHDSnapshots(ctx context.Context, i *ListHyperVVHDSnapshotsInput) (*ListHyperVVHDSnapshotsOutput, error) {
+	klog.V(4).InfoS("ListHyperVVHDSnapshots: called", "args", util.SanitizeRequest(i))
+
+	client := c.hypervClient
+
+	var directories []string
+	var name string
+	switch {
+	case i.SnapshotPath != "":
+		snapshotFile := util.BaseWinPath(i.SnapshotPath)
+		directories = []string{util.DirWinPath(i.SnapshotPath)}
+		name = strings.TrimSuffix(snapshotFile, filepath.Ext(snapshotFile))
+	case i.SourcePath != "":
+		sourceFile := util.BaseWinPath(i.SourcePath)
+		directories = []string{util.JoinWinPath(util.DirWinPath(i.SourcePath), SnapshotsDirectory, strings.TrimSuffix(sourceFile, filepath.Ext(sourceFile)))}
+	default:
+		// Snapshots are next to their source volumes
+		for _, root := range c.managedRoots() {
+			directories = append(directories, util.JoinWinPath(root, SnapshotsDirectory))
+		}
+	}
+
+	var snapshots []hyperv.VHDSnapshot
+	for _, directory := range directories {
+		directorySnapshots, err := client.GetVHDSnapshots(ctx, directory, name)
+		if err != nil {
+			return nil, wrapError(err)
+		}
+		snapshots = append(snapshots, directorySnapshots...)
+	}
+
+	output := &ListHyperVVHDSnapshotsOutput{
+		Snapshots: make([]HyperVVHDSnapshot, 0, len(snapshots)),
+	}
+	seen := map[string]bool{}
+	for _, snapshot := range snapshots {
+		key := strings.ToLower(snapshot.Path)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if i.SnapshotPath != "" && !strings.EqualFold(snapshot.Path, i.SnapshotPath) {
+			continue
+		}
+		if i.SourcePath != "" && !strings.EqualFold(snapshot.SourcePath, i.SourcePath) {
+			continue
+		}
+		output.Snapshots = append(output.Snapshots, c.newHyperVVHDSnapshot(snapshot))
+	}
+
+	return output, nil
+}
+
+func (c *cloud) DeleteHyperVVHDSnapshot(ctx context.Context, i *DeleteHyperVVHDSnapshotInput) (*DeleteHyperVVHDSnapshotOutput, error) {
+	klog.V(4).InfoS("DeleteHyperVVHDSnapshot: called", "args", util.SanitizeRequest(i))
+
+	client := c.hypervClient
+
+	if !c.isManagedPath(i.Path) {
+		return nil, fmt.Errorf("%w: snapshot %s is not in a managed path", ErrInvalidParameter, i.Path)
+	}
+
+	// Differencing volumes restored from the snapshot would be corrupted without their parent.
+	// They are looked up in the base path and next to the source volume of the snapshot.
+	directories := []string{c.vhdBasePath}
+	if sourceDirectory := util.DirWinPath(util.DirWinPath(util.DirWinPath(i.Path))); !strings.EqualFold(sourceDirectory, c.vhdBasePath) {
+		directories = append(directories, sourceDirectory)
+	}
+	for _, directory := range directories {
+		children, err := client.GetVHDChildren(ctx, directory, i.Path)
+		if err != nil {
+			return nil, wrapError(err)
+		}
+		if len(children) > 0 {
+			return nil, fmt.Errorf("%w: snapshot %s is the parent of %s", ErrInUse, i.Path, children[0].Path)
+		}
+	}
+	// Children in other storage paths are only known from the tags of the snapshot
+	if err := c.checkNoChildren(ctx, i.Path); err != nil {
+		return nil, err
+	}
+
+	err := client.DeleteVHDSnapshot(ctx, i.Path)
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return &DeleteHyperVVHDSnapshotOutput{}, nil
+}
+
+func (c *cloud) newHyperVVHDSnapshot(snapshot hyperv.VHDSnapshot) HyperVVHDSnapshot {
+	return HyperVVHDSnapshot{
+		Path:         snapshot.Path,
+		Host:         c.name,
+		SourcePath:   snapshot.SourcePath,
+		Size:         snapshot.Size,
+		CreationTime: time.Unix(snapshot.CreationTime, 0),
+	}
+}
+
+// wrapError maps the errors of the Hyper-V client onto the errors of this package.
+func wrapError(err error) error {
+	switch {
+	case errors.Is(err, winrm.ErrNotFound):
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	case errors.Is(err, winrm.ErrAlreadyExists):
+		return fmt.Errorf("%w: %w", ErrAlreadyExists, err)
+	case errors.Is(err, winrm.ErrInUse):
+		return fmt.Errorf("%w: %w", ErrInUse, err)
+	case errors.Is(err, winrm.ErrInvalidParameter):
+		return fmt.Errorf("%w: %w", ErrInvalidParameter, err)
+	case errors.Is(err, winrm.ErrAccessDenied):
+		return fmt.Errorf("%w: %w", ErrAccessDenied, err)
+	case errors.Is(err, winrm.ErrTimeout):
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	default:
+		return err
+	}
+}
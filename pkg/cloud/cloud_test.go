@@ -0,0 +1,984 @@
+package cloud
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/nhduc2001kt/hyperv-csi-driver/pkg/hyperv"
+	"github.com/nhduc2001kt/hyperv-csi-driver/pkg/util"
+	"github.com/nhduc2001kt/hyperv-csi-driver/pkg/winrm"
+)
+
+// fakeHyperVClient keeps VHDs in memory. Methods that are not overridden panic when called.
+type fakeHyperVClient struct {
+	hyperv.HyperVClient
+	vhds    map[string]hyperv.VHD
+	tags    map[string]map[string]string
+	missing map[string]bool
+	// vmIDs are the VMs each VHD is attached to
+	vmIDs map[string][]string
+	// qosUpdates are the SetVMHardDiskDriveQoS calls, by VM ID
+	qosUpdates map[string]fakeQoSUpdate
+	// snapshots are listed by GetVHDSnapshots
+	snapshots []hyperv.VHDSnapshot
+	// sizeAlignment is what ResizeVHD rounds sizes up to, like Hyper-V does
+	sizeAlignment uint64
+	// directoryChecks counts the DirectoryExists calls that reached the host
+	directoryChecks int
+	volumeLookups   int
+	creates         int
+	resizes         int
+	deletes         int
+}
+
+// fakeQoSUpdate holds the storage QoS values SetVMHardDiskDriveQoS was called with.
+type fakeQoSUpdate struct {
+	path        string
+	maximumIops *uint64
+	minimumIops *uint64
+	qosPolicyID *string
+}
+
+func newFakeHyperVClient(vhds ...hyperv.VHD) *fakeHyperVClient {
+	c := &fakeHyperVClient{
+		vhds:    map[string]hyperv.VHD{},
+		tags:    map[string]map[string]string{},
+		missing: map[string]bool{},
+		vmIDs:   map[string][]string{},
+
+		qosUpdates: map[string]fakeQoSUpdate{},
+	}
+	for _, vhd := range vhds {
+		c.vhds[vhd.Path] = vhd
+	}
+	return c
+}
+
+func (c *fakeHyperVClient) VHDExists(ctx context.Context, path string) (hyperv.VHDExists, error) {
+	_, ok := c.vhds[path]
+	return hyperv.VHDExists{Exists: ok}, nil
+}
+
+func (c *fakeHyperVClient) DirectoryExists(ctx context.Context, directory string) (bool, error) {
+	c.directoryChecks++
+	return !c.missing[directory], nil
+}
+
+func (c *fakeHyperVClient) GetVHD(ctx context.Context, path string) (hyperv.VHD, error) {
+	// Get-VHD.ps1 returns an empty object for missing VHDs
+	return c.vhds[path], nil
+}
+
+func (c *fakeHyperVClient) CreateOrUpdateVHD(ctx context.Context, path string, source string, sourceVm string, sourceDisk int, vhdType hyperv.VHDType, parentPath string, size uint64, blockSize uint32, logicalSectorSize uint32, physicalSectorSize uint32) error {
+	c.creates++
+
+	vhd := hyperv.VHD{
+		Path:               path,
+		VHDType:            vhdType,
+		VHDFormat:          hyperv.VHDFormatVHDX,
+		ParentPath:         parentPath,
+		Size:               size,
+		FileSize:           size,
+		BlockSize:          blockSize,
+		LogicalSectorSize:  logicalSectorSize,
+		PhysicalSectorSize: physicalSectorSize,
+	}
+	if strings.HasSuffix(path, hyperv.VHDFormatExtension[hyperv.VHDFormatVHD]) {
+		vhd.VHDFormat = hyperv.VHDFormatVHD
+	}
+	// Copies and children start with the size of their source
+	if source != "" {
+		vhd.Size = c.vhds[source].Size
+	}
+	if parentPath != "" {
+		vhd.Size = c.vhds[parentPath].Size
+	}
+
+	c.vhds[path] = vhd
+	return nil
+}
+
+func (c *fakeHyperVClient) ResizeVHD(ctx context.Context, path string, size uint64) error {
+	c.resizes++
+
+	if c.sizeAlignment > 0 {
+		size = (size + c.sizeAlignment - 1) / c.sizeAlignment * c.sizeAlignment
+	}
+	vhd := c.vhds[path]
+	vhd.Size = size
+	c.vhds[path] = vhd
+	return nil
+}
+
+func (c *fakeHyperVClient) DeleteVHD(ctx context.Context, path string) error {
+	c.deletes++
+
+	delete(c.vhds, path)
+	delete(c.tags, path)
+	return nil
+}
+
+func (c *fakeHyperVClient) GetVHDTags(ctx context.Context, path string) (map[string]string, error) {
+	tags := map[string]string{}
+	for k, v := range c.tags[path] {
+		tags[k] = v
+	}
+	return tags, nil
+}
+
+func (c *fakeHyperVClient) SetVHDTags(ctx context.Context, path string, tags map[string]string) error {
+	c.tags[path] = tags
+	return nil
+}
+
+func (c *fakeHyperVClient) GetVHDs(ctx context.Context, directory string, path string) ([]hyperv.AttachedVHD, error) {
+	vhds := []hyperv.AttachedVHD{}
+	for _, vhd := range c.vhds {
+		if strings.EqualFold(util.DirWinPath(vhd.Path), strings.TrimRight(directory, `\`)) && (path == "" || vhd.Path == path) {
+			vhds = append(vhds, hyperv.AttachedVHD{VHD: vhd, VMIDs: c.vmIDs[vhd.Path], ParentChainValid: true})
+		}
+	}
+	return vhds, nil
+}
+
+func (c *fakeHyperVClient) SetVMHardDiskDriveQoS(ctx context.Context, vmID string, path string, maximumIops *uint64, minimumIops *uint64, qosPolicyID *string) error {
+	c.qosUpdates[vmID] = fakeQoSUpdate{path: path, maximumIops: maximumIops, minimumIops: minimumIops, qosPolicyID: qosPolicyID}
+	return nil
+}
+
+func (c *fakeHyperVClient) GetVolume(ctx context.Context, path string) (hyperv.Volume, error) {
+	c.volumeLookups++
+	return hyperv.Volume{Size: 100 << 30, SizeRemaining: 40 << 30}, nil
+}
+
+func (c *fakeHyperVClient) CreateVHDSnapshot(ctx context.Context, sourcePath string, path string) (hyperv.VHDSnapshot, error) {
+	// Like Create-VHDSnapshot.ps1, only VHDs mounted on the host itself cannot be checkpointed
+	source := c.vhds[sourcePath]
+	if source.Attached && len(c.vmIDs[sourcePath]) == 0 {
+		return hyperv.VHDSnapshot{}, fmt.Errorf("%w: VHD %s is mounted on the host", winrm.ErrInUse, sourcePath)
+	}
+	snapshot := hyperv.VHDSnapshot{Path: path, SourcePath: sourcePath, Size: source.Size, FileSize: source.FileSize}
+	c.snapshots = append(c.snapshots, snapshot)
+	return snapshot, nil
+}
+
+func (c *fakeHyperVClient) GetVHDSnapshots(ctx context.Context, directory string, name string) ([]hyperv.VHDSnapshot, error) {
+	snapshots := []hyperv.VHDSnapshot{}
+	for _, snapshot := range c.snapshots {
+		if util.IsWinPathWithin(snapshot.Path, directory) {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	return snapshots, nil
+}
+
+func TestCreateHyperVVHD(t *testing.T) {
+	const (
+		basePath   = `C:\VHDs`
+		sourcePath = `C:\VHDs\source.vhdx`
+		giB        = 1 << 30
+	)
+	volumePath := util.JoinWinPath(basePath, "pvc-1.vhdx")
+	source := hyperv.VHD{
+		Path:      sourcePath,
+		VHDType:   hyperv.VHDTypeDynamic,
+		VHDFormat: hyperv.VHDFormatVHDX,
+		Size:      1 * giB,
+	}
+	existing := hyperv.VHD{
+		Path:      volumePath,
+		VHDType:   hyperv.VHDTypeFixed,
+		VHDFormat: hyperv.VHDFormatVHDX,
+		Size:      2 * giB,
+	}
+
+	testCases := []struct {
+		name            string
+		vhds            []hyperv.VHD
+		missing         []string
+		tags            map[string]string
+		input           CreateHyperVVHDInput
+		expectedErr     error
+		expectedTags    map[string]string
+		expectedPath    string
+		expectedSize    uint64
+		expectedCreates int
+		expectedResizes int
+		// expectNoHostCalls is set for inputs that must be rejected before they reach the host
+		expectNoHostCalls bool
+	}{
+		{
+			name: "success: new VHD",
+			input: CreateHyperVVHDInput{
+				Name:   "pvc-1",
+				Type:   hyperv.VHDTypeFixed,
+				Format: hyperv.VHDFormatVHDX,
+				Size:   2 * giB,
+			},
+			expectedSize:    2 * giB,
+			expectedCreates: 1,
+		},
+		{
+			name: "success: existing VHD with the same parameters",
+			vhds: []hyperv.VHD{existing},
+			input: CreateHyperVVHDInput{
+				Name:   "pvc-1",
+				Type:   hyperv.VHDTypeFixed,
+				Format: hyperv.VHDFormatVHDX,
+				Size:   2 * giB,
+			},
+			expectedSize: 2 * giB,
+		},
+		{
+			name: "success: retry keeps the tags added since the VHD was created",
+			vhds: []hyperv.VHD{existing},
+			tags: map[string]string{
+				"owner":        "old",
+				ChildrenTag:    `["C:\\VHDs\\pvc-2.vhdx"]`,
+				MaximumIopsTag: "500",
+			},
+			input: CreateHyperVVHDInput{
+				Name:   "pvc-1",
+				Type:   hyperv.VHDTypeFixed,
+				Format: hyperv.VHDFormatVHDX,
+				Size:   2 * giB,
+				QoS:    HyperVVHDQoS{MaximumIops: 100},
+				Tags:   map[string]string{"owner": "pvc-1"},
+			},
+			expectedSize: 2 * giB,
+			expectedTags: map[string]string{
+				"owner":        "pvc-1",
+				ChildrenTag:    `["C:\\VHDs\\pvc-2.vhdx"]`,
+				MaximumIopsTag: "500",
+			},
+		},
+		{
+			name: "success: new VHD is tagged with the QoS of the request",
+			input: CreateHyperVVHDInput{
+				Name:   "pvc-1",
+				Type:   hyperv.VHDTypeFixed,
+				Format: hyperv.VHDFormatVHDX,
+				Size:   2 * giB,
+				QoS:    HyperVVHDQoS{MaximumIops: 100},
+				Tags:   map[string]string{"owner": "pvc-1"},
+			},
+			expectedSize:    2 * giB,
+			expectedCreates: 1,
+			expectedTags: map[string]string{
+				"owner":        "pvc-1",
+				MaximumIopsTag: "100",
+			},
+		},
+		{
+			name: "fail: existing VHD with another size",
+			vhds: []hyperv.VHD{existing},
+			input: CreateHyperVVHDInput{
+				Name:   "pvc-1",
+				Type:   hyperv.VHDTypeFixed,
+				Format: hyperv.VHDFormatVHDX,
+				Size:   4 * giB,
+			},
+			expectedErr: ErrIdempotentParameterMismatch,
+		},
+		{
+			name: "fail: existing VHD with another type",
+			vhds: []hyperv.VHD{existing},
+			input: CreateHyperVVHDInput{
+				Name:   "pvc-1",
+				Type:   hyperv.VHDTypeDynamic,
+				Format: hyperv.VHDFormatVHDX,
+				Size:   2 * giB,
+			},
+			expectedErr: ErrIdempotentParameterMismatch,
+		},
+		{
+			name: "fail: existing VHD with another format",
+			vhds: []hyperv.VHD{existing},
+			input: CreateHyperVVHDInput{
+				Name:   "pvc-1",
+				Type:   hyperv.VHDTypeFixed,
+				Format: hyperv.VHDFormatVHD,
+				Size:   2 * giB,
+			},
+			expectedErr: ErrIdempotentParameterMismatch,
+		},
+		{
+			name: "fail: existing VHD with another block size",
+			vhds: []hyperv.VHD{existing},
+			input: CreateHyperVVHDInput{
+				Name:      "pvc-1",
+				Type:      hyperv.VHDTypeFixed,
+				Format:    hyperv.VHDFormatVHDX,
+				Size:      2 * giB,
+				BlockSize: 1 << 20,
+			},
+			expectedErr: ErrIdempotentParameterMismatch,
+		},
+		{
+			name: "success: clone is grown to the requested size",
+			vhds: []hyperv.VHD{source},
+			input: CreateHyperVVHDInput{
+				Name:   "pvc-1",
+				Source: sourcePath,
+				Type:   hyperv.VHDTypeDynamic,
+				Format: hyperv.VHDFormatVHDX,
+				Size:   2 * giB,
+			},
+			expectedSize:    2 * giB,
+			expectedCreates: 1,
+			expectedResizes: 1,
+		},
+		{
+			name: "success: interrupted clone is resumed",
+			vhds: []hyperv.VHD{source, {
+				Path:      volumePath,
+				VHDType:   hyperv.VHDTypeDynamic,
+				VHDFormat: hyperv.VHDFormatVHDX,
+				Size:      1 * giB,
+			}},
+			input: CreateHyperVVHDInput{
+				Name:   "pvc-1",
+				Source: sourcePath,
+				Type:   hyperv.VHDTypeDynamic,
+				Format: hyperv.VHDFormatVHDX,
+				Size:   2 * giB,
+			},
+			expectedSize:    2 * giB,
+			expectedResizes: 1,
+		},
+		{
+			name: "success: new VHD in a storage path",
+			input: CreateHyperVVHDInput{
+				Name:      "pvc-1",
+				Directory: `D:\Volumes`,
+				Type:      hyperv.VHDTypeFixed,
+				Format:    hyperv.VHDFormatVHDX,
+				Size:      2 * giB,
+			},
+			expectedPath:    `D:\Volumes\pvc-1.vhdx`,
+			expectedSize:    2 * giB,
+			expectedCreates: 1,
+		},
+		{
+			name:    "fail: storage path does not exist",
+			missing: []string{`D:\Volumes`},
+			input: CreateHyperVVHDInput{
+				Name:      "pvc-1",
+				Directory: `D:\Volumes`,
+				Type:      hyperv.VHDTypeFixed,
+				Format:    hyperv.VHDFormatVHDX,
+				Size:      2 * giB,
+			},
+			expectedErr: ErrInvalidParameter,
+		},
+		{
+			name: "fail: storage path is not a managed path",
+			input: CreateHyperVVHDInput{
+				Name:      "pvc-1",
+				Directory: `E:\Other`,
+				Type:      hyperv.VHDTypeFixed,
+				Format:    hyperv.VHDFormatVHDX,
+				Size:      2 * giB,
+			},
+			expectedErr:       ErrInvalidParameter,
+			expectNoHostCalls: true,
+		},
+		{
+			name: "fail: storage path with a quote is not a managed path",
+			input: CreateHyperVVHDInput{
+				Name:      "pvc-1",
+				Directory: `D:\Volumes'; Remove-Item -Recurse C:\; '`,
+				Type:      hyperv.VHDTypeFixed,
+				Format:    hyperv.VHDFormatVHDX,
+				Size:      2 * giB,
+			},
+			expectedErr:       ErrInvalidParameter,
+			expectNoHostCalls: true,
+		},
+		{
+			name: "success: differencing VHD is registered with its parent",
+			vhds: []hyperv.VHD{source},
+			input: CreateHyperVVHDInput{
+				Name:       "pvc-1",
+				ParentPath: sourcePath,
+				Type:       hyperv.VHDTypeDifferencing,
+				Format:     hyperv.VHDFormatVHDX,
+				Size:       2 * giB,
+			},
+			expectedSize:    2 * giB,
+			expectedCreates: 1,
+			expectedResizes: 1,
+		},
+		{
+			name: "fail: existing differencing VHD with another parent",
+			vhds: []hyperv.VHD{source, {
+				Path:       volumePath,
+				VHDType:    hyperv.VHDTypeDifferencing,
+				VHDFormat:  hyperv.VHDFormatVHDX,
+				ParentPath: `C:\VHDs\other.vhdx`,
+				Size:       2 * giB,
+			}},
+			input: CreateHyperVVHDInput{
+				Name:       "pvc-1",
+				ParentPath: sourcePath,
+				Type:       hyperv.VHDTypeDifferencing,
+				Format:     hyperv.VHDFormatVHDX,
+				Size:       2 * giB,
+			},
+			expectedErr: ErrIdempotentParameterMismatch,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newFakeHyperVClient(tc.vhds...)
+			for _, directory := range tc.missing {
+				client.missing[directory] = true
+			}
+			if tc.tags != nil {
+				client.tags[volumePath] = maps.Clone(tc.tags)
+			}
+			c := &cloud{
+				hypervClient: client,
+				vhdBasePath:  basePath,
+				managedPaths: []string{`D:\Volumes`},
+			}
+
+			output, err := c.CreateHyperVVHD(context.Background(), &tc.input)
+			if tc.expectedErr != nil {
+				if !errors.Is(err, tc.expectedErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+				}
+				if client.creates != 0 {
+					t.Errorf("expected no VHD to be created, got %d", client.creates)
+				}
+				if tc.expectNoHostCalls && client.directoryChecks != 0 {
+					t.Errorf("expected the directory not to be checked on the host, got %d checks", client.directoryChecks)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			expectedPath := volumePath
+			if tc.expectedPath != "" {
+				expectedPath = tc.expectedPath
+			}
+			if output.Path != expectedPath {
+				t.Errorf("expected path %q, got %q", expectedPath, output.Path)
+			}
+			if output.Size != tc.expectedSize {
+				t.Errorf("expected size %d, got %d", tc.expectedSize, output.Size)
+			}
+			if client.creates != tc.expectedCreates {
+				t.Errorf("expected %d creates, got %d", tc.expectedCreates, client.creates)
+			}
+			if client.resizes != tc.expectedResizes {
+				t.Errorf("expected %d resizes, got %d", tc.expectedResizes, client.resizes)
+			}
+			if tc.expectedTags != nil && !maps.Equal(client.tags[output.Path], tc.expectedTags) {
+				t.Errorf("expected tags %v, got %v", tc.expectedTags, client.tags[output.Path])
+			}
+			if tc.input.ParentPath != "" {
+				children, err := childrenFromTags(client.tags[tc.input.ParentPath])
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !containsFold(children, output.Path) {
+					t.Errorf("expected %q to be registered with parent %q, got %v", output.Path, tc.input.ParentPath, children)
+				}
+			}
+		})
+	}
+}
+
+func TestExpandHyperVVHD(t *testing.T) {
+	const (
+		path = `C:\VHDs\pvc-1.vhdx`
+		giB  = 1 << 30
+		miB  = 1 << 20
+	)
+
+	testCases := []struct {
+		name            string
+		size            uint64
+		path            string
+		expectedSize    uint64
+		expectedResizes int
+		expectedErr     error
+	}{
+		{
+			name:            "success: size aligned by the host",
+			size:            2*giB + 1,
+			path:            path,
+			expectedSize:    2*giB + miB,
+			expectedResizes: 1,
+		},
+		{
+			name:         "success: VHD already larger",
+			size:         giB,
+			path:         path,
+			expectedSize: 2 * giB,
+		},
+		{
+			name:        "fail: VHD not found",
+			size:        4 * giB,
+			path:        `C:\VHDs\missing.vhdx`,
+			expectedErr: ErrNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newFakeHyperVClient(hyperv.VHD{Path: path, Size: 2 * giB})
+			client.sizeAlignment = miB
+			c := &cloud{hypervClient: client, vhdBasePath: `C:\VHDs`}
+
+			output, err := c.ExpandHyperVVHD(context.Background(), &ExpandHyperVVHDInput{Path: tc.path, Size: tc.size})
+			if tc.expectedErr != nil {
+				if !errors.Is(err, tc.expectedErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if output.Size != tc.expectedSize {
+				t.Errorf("expected size %d, got %d", tc.expectedSize, output.Size)
+			}
+			if client.resizes != tc.expectedResizes {
+				t.Errorf("expected %d resizes, got %d", tc.expectedResizes, client.resizes)
+			}
+		})
+	}
+}
+
+func TestModifyHyperVVHD(t *testing.T) {
+	const (
+		path = `C:\VHDs\pvc-1.vhdx`
+		vmID = "3f2d2c6e-5a4b-4c1d-9e8f-7a6b5c4d3e2f"
+
+		otherTag = "owner"
+	)
+	var (
+		zero     = uint64(0)
+		iops     = uint64(500)
+		noPolicy = ""
+	)
+	currentTags := map[string]string{
+		otherTag:       "kept",
+		MaximumIopsTag: "1000",
+		MinimumIopsTag: "100",
+		QosPolicyIDTag: "policy",
+	}
+
+	testCases := []struct {
+		name         string
+		input        *ModifyHyperVVHDInput
+		expectedTags map[string]string
+		expectedErr  error
+	}{
+		{
+			name:  "success: limit changed",
+			input: &ModifyHyperVVHDInput{Path: path, MaximumIops: &iops},
+			expectedTags: map[string]string{
+				otherTag:       "kept",
+				MaximumIopsTag: "500",
+				MinimumIopsTag: "100",
+				QosPolicyIDTag: "policy",
+			},
+		},
+		{
+			name:  "success: limits and policy removed",
+			input: &ModifyHyperVVHDInput{Path: path, MaximumIops: &zero, MinimumIops: &zero, QosPolicyID: &noPolicy},
+			expectedTags: map[string]string{
+				otherTag: "kept",
+			},
+		},
+		{
+			name:        "fail: VHD not found",
+			input:       &ModifyHyperVVHDInput{Path: `C:\VHDs\missing.vhdx`, MaximumIops: &iops},
+			expectedErr: ErrNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newFakeHyperVClient(hyperv.VHD{Path: path})
+			client.vmIDs[path] = []string{vmID}
+			client.tags[path] = maps.Clone(currentTags)
+			c := &cloud{hypervClient: client, vhdBasePath: `C:\VHDs`}
+
+			_, err := c.ModifyHyperVVHD(context.Background(), tc.input)
+			if tc.expectedErr != nil {
+				if !errors.Is(err, tc.expectedErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !maps.Equal(client.tags[path], tc.expectedTags) {
+				t.Errorf("expected tags %v, got %v", tc.expectedTags, client.tags[path])
+			}
+
+			// The values of the request reach the VM as they are, zero values included
+			update, ok := client.qosUpdates[vmID]
+			if !ok {
+				t.Fatalf("expected the QoS of VM %s to be set", vmID)
+			}
+			if update.path != path || update.maximumIops != tc.input.MaximumIops || update.minimumIops != tc.input.MinimumIops || update.qosPolicyID != tc.input.QosPolicyID {
+				t.Errorf("expected QoS %+v to be set, got %+v", tc.input, update)
+			}
+		})
+	}
+}
+
+func TestGetHyperVCapacity(t *testing.T) {
+	testCases := []struct {
+		name        string
+		directory   string
+		missing     []string
+		expectedErr error
+		// expectNoHostCalls is set for inputs that must be rejected before they reach the host
+		expectNoHostCalls bool
+	}{
+		{
+			name: "success: VHD base path",
+		},
+		{
+			name:      "success: storage path",
+			directory: `D:\Volumes`,
+		},
+		{
+			name:        "fail: storage path does not exist",
+			directory:   `D:\Volumes`,
+			missing:     []string{`D:\Volumes`},
+			expectedErr: ErrInvalidParameter,
+		},
+		{
+			name:              "fail: storage path is not a managed path",
+			directory:         `E:\Other`,
+			expectedErr:       ErrInvalidParameter,
+			expectNoHostCalls: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newFakeHyperVClient()
+			for _, directory := range tc.missing {
+				client.missing[directory] = true
+			}
+			c := &cloud{
+				hypervClient: client,
+				vhdBasePath:  `C:\VHDs`,
+				managedPaths: []string{`D:\Volumes`},
+			}
+
+			output, err := c.GetHyperVCapacity(context.Background(), &GetHyperVCapacityInput{Directory: tc.directory})
+			if tc.expectedErr != nil {
+				if !errors.Is(err, tc.expectedErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+				}
+				if client.volumeLookups != 0 {
+					t.Errorf("expected no volume to be looked up, got %d lookups", client.volumeLookups)
+				}
+				if tc.expectNoHostCalls && client.directoryChecks != 0 {
+					t.Errorf("expected the directory not to be checked on the host, got %d checks", client.directoryChecks)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if output.TotalBytes != 100<<30 || output.AvailableBytes != 40<<30 {
+				t.Errorf("expected the size of the host volume, got %+v", output)
+			}
+		})
+	}
+}
+
+func TestDeleteHyperVVHD(t *testing.T) {
+	const (
+		parentPath = `C:\VHDs\base.vhdx`
+		childPath  = `D:\Runners\pvc-1.vhdx`
+		giB        = 1 << 30
+	)
+	parent := hyperv.VHD{
+		Path:      parentPath,
+		VHDType:   hyperv.VHDTypeDynamic,
+		VHDFormat: hyperv.VHDFormatVHDX,
+		Size:      1 * giB,
+	}
+	child := hyperv.VHD{
+		Path:       childPath,
+		VHDType:    hyperv.VHDTypeDifferencing,
+		VHDFormat:  hyperv.VHDFormatVHDX,
+		ParentPath: parentPath,
+		Size:       1 * giB,
+	}
+
+	ownerTags := map[string]string{"kubernetes.io/cluster/test": "owned"}
+
+	testCases := []struct {
+		name            string
+		vhds            []hyperv.VHD
+		children        []string
+		tags            map[string]string
+		path            string
+		ownerTags       map[string]string
+		allowUntagged   bool
+		expectedErr     error
+		expectedDeletes int
+	}{
+		{
+			name:            "success: VHD without children",
+			vhds:            []hyperv.VHD{parent},
+			path:            parentPath,
+			expectedDeletes: 1,
+		},
+		{
+			name:            "success: registered child no longer exists",
+			vhds:            []hyperv.VHD{parent},
+			children:        []string{childPath},
+			path:            parentPath,
+			expectedDeletes: 1,
+		},
+		{
+			name:        "fail: parent of an existing child",
+			vhds:        []hyperv.VHD{parent, child},
+			children:    []string{childPath},
+			path:        parentPath,
+			expectedErr: ErrInUse,
+		},
+		{
+			name:            "success: child is unregistered from its parent",
+			vhds:            []hyperv.VHD{parent, child},
+			children:        []string{childPath},
+			path:            childPath,
+			expectedDeletes: 1,
+		},
+		{
+			name:            "success: missing VHD",
+			path:            childPath,
+			expectedDeletes: 1,
+		},
+		{
+			name:        "fail: VHD is attached",
+			vhds:        []hyperv.VHD{{Path: childPath, VHDType: hyperv.VHDTypeDynamic, Attached: true}},
+			path:        childPath,
+			expectedErr: ErrInUse,
+		},
+		{
+			name:        "fail: VHD is not in a managed path",
+			vhds:        []hyperv.VHD{{Path: `E:\Other\pvc-1.vhdx`, VHDType: hyperv.VHDTypeDynamic}},
+			path:        `E:\Other\pvc-1.vhdx`,
+			expectedErr: ErrInvalidParameter,
+		},
+		{
+			name:        "fail: VHD path leaves the managed path",
+			path:        `C:\VHDs\..\Windows\pvc-1.vhdx`,
+			expectedErr: ErrInvalidParameter,
+		},
+		{
+			name:            "success: VHD is owned by the cluster",
+			vhds:            []hyperv.VHD{child},
+			tags:            ownerTags,
+			path:            childPath,
+			ownerTags:       ownerTags,
+			expectedDeletes: 1,
+		},
+		{
+			name:            "success: VHD created before tags is owned when allowed",
+			vhds:            []hyperv.VHD{child},
+			path:            childPath,
+			ownerTags:       ownerTags,
+			allowUntagged:   true,
+			expectedDeletes: 1,
+		},
+		{
+			name:        "fail: VHD without tags is not owned",
+			vhds:        []hyperv.VHD{child},
+			path:        childPath,
+			ownerTags:   ownerTags,
+			expectedErr: ErrAccessDenied,
+		},
+		{
+			name:          "fail: VHD with only the children tag is not owned",
+			vhds:          []hyperv.VHD{parent},
+			children:      []string{childPath},
+			path:          parentPath,
+			ownerTags:     ownerTags,
+			allowUntagged: true,
+			expectedErr:   ErrAccessDenied,
+		},
+		{
+			name:        "fail: VHD is not owned by the cluster",
+			vhds:        []hyperv.VHD{child},
+			tags:        map[string]string{"kubernetes.io/cluster/other": "owned"},
+			path:        childPath,
+			ownerTags:   ownerTags,
+			expectedErr: ErrAccessDenied,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newFakeHyperVClient(tc.vhds...)
+			if len(tc.children) > 0 {
+				value, _ := json.Marshal(tc.children)
+				client.tags[parentPath] = map[string]string{ChildrenTag: string(value)}
+			}
+			if tc.tags != nil {
+				client.tags[tc.path] = tc.tags
+			}
+			c := &cloud{
+				hypervClient: client,
+				vhdBasePath:  `C:\VHDs`,
+				managedPaths: []string{`D:\Runners`},
+			}
+
+			_, err := c.DeleteHyperVVHD(context.Background(), &DeleteHyperVVHDInput{Path: tc.path, OwnerTags: tc.ownerTags, AllowUntagged: tc.allowUntagged})
+			if tc.expectedErr != nil {
+				if !errors.Is(err, tc.expectedErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if client.deletes != tc.expectedDeletes {
+				t.Errorf("expected %d deletes, got %d", tc.expectedDeletes, client.deletes)
+			}
+			if tc.path == childPath && tc.expectedErr == nil {
+				if _, ok := client.tags[parentPath][ChildrenTag]; ok {
+					t.Errorf("expected %s to be unregistered from its parent, got tags %v", childPath, client.tags[parentPath])
+				}
+			}
+		})
+	}
+}
+
+func TestListHyperVVHDs(t *testing.T) {
+	client := newFakeHyperVClient(
+		hyperv.VHD{Path: `C:\VHDs\pvc-1.vhdx`},
+		hyperv.VHD{Path: `D:\Volumes\pvc-2.vhdx`},
+		hyperv.VHD{Path: `E:\Other\pvc-3.vhdx`},
+	)
+	// The base path is also listed as a managed path, in another case
+	c := &cloud{
+		hypervClient: client,
+		vhdBasePath:  `C:\VHDs`,
+		managedPaths: []string{`c:\vhds\`, `D:\Volumes`},
+	}
+
+	output, err := c.ListHyperVVHDs(context.Background(), &ListHyperVVHDsInput{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	paths := []string{}
+	for _, vhd := range output.VHDs {
+		paths = append(paths, vhd.Path)
+	}
+	slices.Sort(paths)
+	expected := []string{`C:\VHDs\pvc-1.vhdx`, `D:\Volumes\pvc-2.vhdx`}
+	if !slices.Equal(paths, expected) {
+		t.Errorf("expected VHDs %v, got %v", expected, paths)
+	}
+}
+
+func TestCreateHyperVVHDSnapshot(t *testing.T) {
+	const (
+		sourcePath = `C:\VHDs\pvc-1.vhdx`
+		vmID       = "3f2d2c6e-5a4b-4c1d-9e8f-7a6b5c4d3e2f"
+		giB        = 1 << 30
+	)
+
+	testCases := []struct {
+		name         string
+		source       hyperv.VHD
+		vmIDs        []string
+		expectedPath string
+		expectedErr  error
+	}{
+		{
+			name:         "success: detached source",
+			source:       hyperv.VHD{Path: sourcePath, Size: giB},
+			expectedPath: `C:\VHDs\Snapshots\pvc-1\snapshot-1.vhdx`,
+		},
+		{
+			name:         "success: source attached to a VM",
+			source:       hyperv.VHD{Path: sourcePath, Size: giB, Attached: true, DiskNumber: 3},
+			vmIDs:        []string{vmID},
+			expectedPath: `C:\VHDs\Snapshots\pvc-1\snapshot-1.vhdx`,
+		},
+		{
+			name:        "fail: source mounted on the host",
+			source:      hyperv.VHD{Path: sourcePath, Size: giB, Attached: true, DiskNumber: 3},
+			expectedErr: ErrInUse,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newFakeHyperVClient(tc.source)
+			client.vmIDs[sourcePath] = tc.vmIDs
+			c := &cloud{hypervClient: client, vhdBasePath: `C:\VHDs`}
+
+			output, err := c.CreateHyperVVHDSnapshot(context.Background(), &CreateHyperVVHDSnapshotInput{
+				Name:       "snapshot-1",
+				SourcePath: sourcePath,
+			})
+			if tc.expectedErr != nil {
+				if !errors.Is(err, tc.expectedErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if output.Path != tc.expectedPath || output.SourcePath != sourcePath || output.Size != giB {
+				t.Errorf("expected snapshot %s of %s, got %+v", tc.expectedPath, sourcePath, output.HyperVVHDSnapshot)
+			}
+		})
+	}
+}
+
+func TestListHyperVVHDSnapshots(t *testing.T) {
+	client := newFakeHyperVClient()
+	client.snapshots = []hyperv.VHDSnapshot{
+		{Path: `C:\VHDs\Snapshots\pvc-1\snap-1.vhdx`, SourcePath: `C:\VHDs\pvc-1.vhdx`},
+		{Path: `D:\Volumes\Snapshots\pvc-2\snap-2.vhdx`, SourcePath: `D:\Volumes\pvc-2.vhdx`},
+		{Path: `E:\Other\Snapshots\pvc-3\snap-3.vhdx`, SourcePath: `E:\Other\pvc-3.vhdx`},
+	}
+	c := &cloud{
+		hypervClient: client,
+		vhdBasePath:  `C:\VHDs`,
+		managedPaths: []string{`C:\VHDs`, `D:\Volumes`},
+	}
+
+	output, err := c.ListHyperVVHDSnapshots(context.Background(), &ListHyperVVHDSnapshotsInput{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	paths := []string{}
+	for _, snapshot := range output.Snapshots {
+		paths = append(paths, snapshot.Path)
+	}
+	slices.Sort(paths)
+	expected := []string{`C:\VHDs\Snapshots\pvc-1\snap-1.vhdx`, `D:\Volumes\Snapshots\pvc-2\snap-2.vhdx`}
+	if !slices.Equal(paths, expected) {
+		t.Errorf("expected snapshots %v, got %v", expected, paths)
+	}
+}
@@ -0,0 +1,357 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nhduc2001kt/hyperv-csi-driver/options"
+	"github.com/nhduc2001kt/hyperv-csi-driver/pkg/hyperv/hypervwinrmimpl"
+	"k8s.io/klog/v2"
+)
+
+// hostRegistry implements Cloud over several named Hyper-V hosts. VHDs are local to the host
+// they were created on, so every call is dispatched to the host that holds its VHD.
+type hostRegistry struct {
+	// hosts are sorted by name, the first one is used when nothing selects a host
+	hosts []*cloud
+
+	mux sync.RWMutex
+	// pathHosts caches the host of each VHD path that has been looked up
+	pathHosts map[string]*cloud
+}
+
+var _ Cloud = &hostRegistry{}
+
+// newHostRegistry creates a WinRM client pool for each host of --hyperv-hosts, or for --winrm-host
+// if no hosts are named.
+func newHostRegistry(opts *options.Options) (*hostRegistry, error) {
+	r := &hostRegistry{
+		pathHosts: map[string]*cloud{},
+	}
+
+	if len(opts.HyperVHosts) == 0 {
+		c, err := newHostCloud("", opts)
+		if err != nil {
+			return nil, err
+		}
+		r.hosts = append(r.hosts, c)
+		return r, nil
+	}
+
+	names := make([]string, 0, len(opts.HyperVHosts))
+	for name := range opts.HyperVHosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		hostOpts := *opts
+		address := opts.HyperVHosts[name]
+		if host, port, err := net.SplitHostPort(address); err == nil {
+			hostOpts.WinRMHost = host
+			hostOpts.WinRMPort, err = strconv.Atoi(port)
+			if err != nil {
+				return nil, fmt.Errorf("invalid port in address %q of Hyper-V host %s: %w", address, name, err)
+			}
+		} else {
+			hostOpts.WinRMHost = address
+		}
+
+		c, err := newHostCloud(name, &hostOpts)
+		if err != nil {
+			return nil, fmt.Errorf("could not create client for Hyper-V host %s: %w", name, err)
+		}
+		r.hosts = append(r.hosts, c)
+	}
+
+	return r, nil
+}
+
+func newHostCloud(name string, opts *options.Options) (*cloud, error) {
+	hypervClient, err := hypervwinrmimpl.NewClient(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	vhdBasePath := DefaultVHDBasePath
+	if opts.VHDBasePath != "" {
+		vhdBasePath = opts.VHDBasePath
+	}
+
+	return &cloud{
+		name:         name,
+		hypervClient: hypervClient,
+		vhdBasePath:  vhdBasePath,
+		managedPaths: opts.ManagedVHDPaths,
+	}, nil
+}
+
+// hostByName returns the host registered under a name. Host names are case insensitive, like
+// Windows computer names.
+func (r *hostRegistry) hostByName(name string) *cloud {
+	for _, host := range r.hosts {
+		if strings.EqualFold(host.name, name) {
+			return host
+		}
+	}
+	return nil
+}
+
+// hostForPath returns the host that holds the VHD at path.
+func (r *hostRegistry) hostForPath(ctx context.Context, path string) (*cloud, error) {
+	if len(r.hosts) == 1 {
+		return r.hosts[0], nil
+	}
+
+	key := strings.ToLower(path)
+	r.mux.RLock()
+	host, ok := r.pathHosts[key]
+	r.mux.RUnlock()
+	if ok {
+		return host, nil
+	}
+
+	for _, host := range r.hosts {
+		exists, err := host.hypervClient.VHDExists(ctx, path)
+		if err != nil {
+			return nil, fmt.Errorf("could not look up VHD %s on Hyper-V host %s: %w", path, host.name, wrapError(err))
+		}
+		if exists.Exists {
+			r.mux.Lock()
+			r.pathHosts[key] = host
+			r.mux.Unlock()
+			return host, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: VHD %s on any Hyper-V host", ErrNotFound, path)
+}
+
+// hostForVHD returns the named host of a VHD, or the host that holds the VHD at path if the host is
+// not named or is no longer registered.
+func (r *hostRegistry) hostForVHD(ctx context.Context, name, path string) (*cloud, error) {
+	if name != "" {
+		if host := r.hostByName(name); host != nil {
+			return host, nil
+		}
+	}
+	return r.hostForPath(ctx, path)
+}
+
+func (r *hostRegistry) forgetPath(path string) {
+	r.mux.Lock()
+	delete(r.pathHosts, strings.ToLower(path))
+	r.mux.Unlock()
+}
+
+// hostForCreate returns the host a new VHD is created on. Clones must stay on the host of their
+// source, other VHDs go to the first of the requested hosts that is registered.
+func (r *hostRegistry) hostForCreate(ctx context.Context, i *CreateHyperVVHDInput) (*cloud, error) {
+	source := i.Source
+	if i.ParentPath != "" {
+		source = i.ParentPath
+	}
+	if source != "" {
+		host, err := r.hostForVHD(ctx, i.SourceHost, source)
+		if err != nil {
+			return nil, err
+		}
+		if host.name != "" && len(i.Hosts) > 0 && !containsFold(i.Hosts, host.name) {
+			return nil, fmt.Errorf("%w: source %s is on Hyper-V host %s, which is not one of the requested hosts %v", ErrInvalidParameter, source, host.name, i.Hosts)
+		}
+		return host, nil
+	}
+
+	// A single unnamed host serves every topology
+	if len(i.Hosts) == 0 || (len(r.hosts) == 1 && r.hosts[0].name == "") {
+		return r.hosts[0], nil
+	}
+	for _, name := range i.Hosts {
+		if host := r.hostByName(name); host != nil {
+			return host, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: none of the requested Hyper-V hosts %v is registered", ErrInvalidParameter, i.Hosts)
+}
+
+func containsFold(s []string, v string) bool {
+	for _, e := range s {
+		if strings.EqualFold(e, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *hostRegistry) GetHyperVVHD(ctx context.Context, i *GetHyperVVHDInput) (*GetHyperVVHDOutput, error) {
+	host, err := r.hostForVHD(ctx, i.Host, i.Path)
+	if err != nil {
+		return nil, err
+	}
+	return host.GetHyperVVHD(ctx, i)
+}
+
+func (r *hostRegistry) ListHyperVVHDs(ctx context.Context, i *ListHyperVVHDsInput) (*ListHyperVVHDsOutput, error) {
+	// VHDs whose file is missing are only found by listing every host
+	if i.Path != "" {
+		host, err := r.hostForVHD(ctx, i.Host, i.Path)
+		if err == nil {
+			return host.ListHyperVVHDs(ctx, i)
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+	}
+
+	output := &ListHyperVVHDsOutput{}
+	for _, host := range r.hosts {
+		hostOutput, err := host.ListHyperVVHDs(ctx, i)
+		if err != nil {
+			return nil, err
+		}
+		output.VHDs = append(output.VHDs, hostOutput.VHDs...)
+	}
+
+	// Listed VHDs need no lookup in later calls
+	r.mux.Lock()
+	for _, vhd := range output.VHDs {
+		if host := r.hostByName(vhd.Host); host != nil {
+			r.pathHosts[strings.ToLower(vhd.Path)] = host
+		}
+	}
+	r.mux.Unlock()
+
+	return output, nil
+}
+
+func (r *hostRegistry) CreateHyperVVHD(ctx context.Context, i *CreateHyperVVHDInput) (*CreateHyperVVHDOutput, error) {
+	host, err := r.hostForCreate(ctx, i)
+	if err != nil {
+		return nil, err
+	}
+	klog.V(4).InfoS("CreateHyperVVHD: selected Hyper-V host", "name", i.Name, "host", host.name)
+	return host.CreateHyperVVHD(ctx, i)
+}
+
+func (r *hostRegistry) ExpandHyperVVHD(ctx context.Context, i *ExpandHyperVVHDInput) (*ExpandHyperVVHDOutput, error) {
+	host, err := r.hostForVHD(ctx, i.Host, i.Path)
+	if err != nil {
+		return nil, err
+	}
+	return host.ExpandHyperVVHD(ctx, i)
+}
+
+func (r *hostRegistry) ModifyHyperVVHD(ctx context.Context, i *ModifyHyperVVHDInput) (*ModifyHyperVVHDOutput, error) {
+	host, err := r.hostForVHD(ctx, i.Host, i.Path)
+	if err != nil {
+		return nil, err
+	}
+	return host.ModifyHyperVVHD(ctx, i)
+}
+
+func (r *hostRegistry) DeleteHyperVVHD(ctx context.Context, i *DeleteHyperVVHDInput) (*DeleteHyperVVHDOutput, error) {
+	host, err := r.hostForVHD(ctx, i.Host, i.Path)
+	if err != nil {
+		return nil, err
+	}
+	output, err := host.DeleteHyperVVHD(ctx, i)
+	if err != nil {
+		return nil, err
+	}
+	r.forgetPath(i.Path)
+	return output, nil
+}
+
+func (r *hostRegistry) GetHyperVCapacity(ctx context.Context, i *GetHyperVCapacityInput) (*GetHyperVCapacityOutput, error) {
+	if i.Host != "" && !(len(r.hosts) == 1 && r.hosts[0].name == "") {
+		host := r.hostByName(i.Host)
+		if host == nil {
+			return nil, fmt.Errorf("%w: Hyper-V host %s", ErrNotFound, i.Host)
+		}
+		return host.GetHyperVCapacity(ctx, i)
+	}
+
+	// A volume cannot span hosts, so the largest host is what can be provisioned
+	output := &GetHyperVCapacityOutput{}
+	for _, host := range r.hosts {
+		hostOutput, err := host.GetHyperVCapacity(ctx, i)
+		if err != nil {
+			return nil, err
+		}
+		if hostOutput.AvailableBytes >= output.AvailableBytes {
+			output = hostOutput
+		}
+	}
+	return output, nil
+}
+
+func (r *hostRegistry) AttachHyperVVHD(ctx context.Context, i *AttachHyperVVHDInput) (*AttachHyperVVHDOutput, error) {
+	host, err := r.hostForVHD(ctx, i.Host, i.VHDPath)
+	if err != nil {
+		return nil, err
+	}
+	return host.AttachHyperVVHD(ctx, i)
+}
+
+func (r *hostRegistry) DetachHyperVVHD(ctx context.Context, i *DetachHyperVVHDInput) (*DetachHyperVVHDOutput, error) {
+	host, err := r.hostForVHD(ctx, i.Host, i.VHDPath)
+	if err != nil {
+		return nil, err
+	}
+	return host.DetachHyperVVHD(ctx, i)
+}
+
+func (r *hostRegistry) CreateHyperVVHDSnapshot(ctx context.Context, i *CreateHyperVVHDSnapshotInput) (*CreateHyperVVHDSnapshotOutput, error) {
+	host, err := r.hostForVHD(ctx, i.SourceHost, i.SourcePath)
+	if err != nil {
+		return nil, err
+	}
+	return host.CreateHyperVVHDSnapshot(ctx, i)
+}
+
+func (r *hostRegistry) ListHyperVVHDSnapshots(ctx context.Context, i *ListHyperVVHDSnapshotsInput) (*ListHyperVVHDSnapshotsOutput, error) {
+	path := i.SnapshotPath
+	if path == "" {
+		path = i.SourcePath
+	}
+	if path != "" {
+		host, err := r.hostForVHD(ctx, i.Host, path)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return &ListHyperVVHDSnapshotsOutput{}, nil
+			}
+			return nil, err
+		}
+		return host.ListHyperVVHDSnapshots(ctx, i)
+	}
+
+	output := &ListHyperVVHDSnapshotsOutput{}
+	for _, host := range r.hosts {
+		hostOutput, err := host.ListHyperVVHDSnapshots(ctx, i)
+		if err != nil {
+			return nil, err
+		}
+		output.Snapshots = append(output.Snapshots, hostOutput.Snapshots...)
+	}
+	return output, nil
+}
+
+func (r *hostRegistry) DeleteHyperVVHDSnapshot(ctx context.Context, i *DeleteHyperVVHDSnapshotInput) (*DeleteHyperVVHDSnapshotOutput, error) {
+	host, err := r.hostForVHD(ctx, i.Host, i.Path)
+	if err != nil {
+		return nil, err
+	}
+	output, err := host.DeleteHyperVVHDSnapshot(ctx, i)
+	if err != nil {
+		return nil, err
+	}
+	r.forgetPath(i.Path)
+	return output, nil
+}
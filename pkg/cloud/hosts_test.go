@@ -0,0 +1,159 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nhduc2001kt/hyperv-csi-driver/pkg/hyperv"
+)
+
+func TestHostForCreate(t *testing.T) {
+	const sourcePath = `C:\VHDs\source.vhdx`
+	newRegistry := func(names ...string) *hostRegistry {
+		r := &hostRegistry{pathHosts: map[string]*cloud{}}
+		for _, name := range names {
+			client := newFakeHyperVClient()
+			if name == "host-b" {
+				client.vhds[sourcePath] = hyperv.VHD{Path: sourcePath}
+			}
+			r.hosts = append(r.hosts, &cloud{name: name, hypervClient: client})
+		}
+		return r
+	}
+
+	testCases := []struct {
+		name         string
+		registry     *hostRegistry
+		input        CreateHyperVVHDInput
+		expectedHost string
+		expectedErr  error
+	}{
+		{
+			name:         "success: first host without requirements",
+			registry:     newRegistry("host-a", "host-b"),
+			expectedHost: "host-a",
+		},
+		{
+			name:         "success: first registered requested host",
+			registry:     newRegistry("host-a", "host-b"),
+			input:        CreateHyperVVHDInput{Hosts: []string{"host-c", "HOST-B", "host-a"}},
+			expectedHost: "host-b",
+		},
+		{
+			name:         "success: unnamed host serves any topology",
+			registry:     newRegistry(""),
+			input:        CreateHyperVVHDInput{Hosts: []string{"host-c"}},
+			expectedHost: "",
+		},
+		{
+			name:        "fail: no requested host is registered",
+			registry:    newRegistry("host-a", "host-b"),
+			input:       CreateHyperVVHDInput{Hosts: []string{"host-c"}},
+			expectedErr: ErrInvalidParameter,
+		},
+		{
+			name:         "success: clone stays on the host of its source",
+			registry:     newRegistry("host-a", "host-b"),
+			input:        CreateHyperVVHDInput{Source: sourcePath},
+			expectedHost: "host-b",
+		},
+		{
+			name:         "success: named source host is used without lookup",
+			registry:     newRegistry("host-a", "host-b"),
+			input:        CreateHyperVVHDInput{Source: `C:\VHDs\other.vhdx`, SourceHost: "host-a"},
+			expectedHost: "host-a",
+		},
+		{
+			name:        "fail: source is on another host than requested",
+			registry:    newRegistry("host-a", "host-b"),
+			input:       CreateHyperVVHDInput{Source: sourcePath, Hosts: []string{"host-a"}},
+			expectedErr: ErrInvalidParameter,
+		},
+		{
+			name:        "fail: source does not exist",
+			registry:    newRegistry("host-a", "host-b"),
+			input:       CreateHyperVVHDInput{Source: `C:\VHDs\missing.vhdx`},
+			expectedErr: ErrNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			host, err := tc.registry.hostForCreate(context.Background(), &tc.input)
+			if tc.expectedErr != nil {
+				if !errors.Is(err, tc.expectedErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if host.name != tc.expectedHost {
+				t.Errorf("expected host %q, got %q", tc.expectedHost, host.name)
+			}
+		})
+	}
+}
+
+func TestHostForVHD(t *testing.T) {
+	const path = `C:\VHDs\pvc-1.vhdx`
+	r := &hostRegistry{pathHosts: map[string]*cloud{}}
+	for _, name := range []string{"host-a", "host-b"} {
+		client := newFakeHyperVClient()
+		if name == "host-b" {
+			client.vhds[path] = hyperv.VHD{Path: path}
+		}
+		r.hosts = append(r.hosts, &cloud{name: name, hypervClient: client})
+	}
+
+	testCases := []struct {
+		name         string
+		host         string
+		path         string
+		expectedHost string
+		expectedErr  error
+	}{
+		{
+			name:         "success: named host is used without lookup",
+			host:         "HOST-A",
+			path:         path,
+			expectedHost: "host-a",
+		},
+		{
+			name:         "success: unnamed host is looked up",
+			path:         path,
+			expectedHost: "host-b",
+		},
+		{
+			name:         "success: host that is no longer registered is looked up",
+			host:         "host-c",
+			path:         path,
+			expectedHost: "host-b",
+		},
+		{
+			name:        "fail: VHD is on no host",
+			path:        `C:\VHDs\missing.vhdx`,
+			expectedErr: ErrNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			host, err := r.hostForVHD(context.Background(), tc.host, tc.path)
+			if tc.expectedErr != nil {
+				if !errors.Is(err, tc.expectedErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if host.name != tc.expectedHost {
+				t.Errorf("expected host %q, got %q", tc.expectedHost, host.name)
+			}
+		})
+	}
+}
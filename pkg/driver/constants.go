@@ -4,6 +4,13 @@ const (
 	DriverName = "hyperv.csi.k8s.io"
 )
 
+// constants for topology.
+const (
+	// TopologyKey is the key of the topology segment holding the name of the Hyper-V host
+	// a node runs on.
+	TopologyKey = "topology." + DriverName + "/host"
+)
+
 // constants for node k8s API use.
 const (
 	// AgentNotReadyNodeTaintKey contains the key of taints to be removed on driver startup.
@@ -60,8 +67,56 @@ const (
 	VHDFormatKey = "format"
 
 	// VHDBlockSizeKey represents key for the block size, in bytes, of the virtual hard disk to
-	// be created.
-	VHDBlockSizeKey = "blockSize"
+	// be created. It does not set the block size of the filesystem, see FSBlockSizeKey.
+	VHDBlockSizeKey = "blocksize"
+
+	// LogicalSectorSizeKey represents key for the logical sector size, in bytes, of the virtual
+	// hard disk to be created. Valid values are 512 and 4096.
+	LogicalSectorSizeKey = "logicalsectorsize"
+
+	// PhysicalSectorSizeKey represents key for the physical sector size, in bytes, of the virtual
+	// hard disk to be created. Valid values are 512 and 4096.
+	PhysicalSectorSizeKey = "physicalsectorsize"
+
+	// CloneModeKey represents key for how a volume is created from a snapshot or another volume.
+	// Valid values are copy (default) and differencing.
+	CloneModeKey = "clonemode"
+
+	// ParentPathKey represents key for the path, on the Hyper-V host, of a VHD that volumes
+	// are created from as differencing VHDs, e.g. a golden image.
+	ParentPathKey = "parentpath"
+
+	// ParentVolumeIDKey represents key for the ID of a volume that volumes are created from as
+	// differencing VHDs. It is an alternative to ParentPathKey.
+	ParentVolumeIDKey = "parentvolumeid"
+
+	// StoragePathKey represents key for the directory on the Hyper-V host where the volume is
+	// created. It overrides the --vhd-base-path option of the driver.
+	StoragePathKey = "storagepath"
+
+	// MaximumIopsKey represents key for the maximum normalized IOPS of the VM hard disk drive
+	// a volume is attached as. It is also a mutable parameter of VolumeAttributesClasses.
+	MaximumIopsKey = "maximumiops"
+
+	// MinimumIopsKey represents key for the minimum normalized IOPS of the VM hard disk drive
+	// a volume is attached as. It is also a mutable parameter of VolumeAttributesClasses.
+	MinimumIopsKey = "minimumiops"
+
+	// QosPolicyIDKey represents key for the ID of the storage QoS policy of the VM hard disk
+	// drive a volume is attached as. It is also a mutable parameter of VolumeAttributesClasses.
+	QosPolicyIDKey = "qospolicyid"
+
+	// CacheAttributesKey represents key for the cache attributes of the VM hard disk drive a
+	// volume is attached as. Valid values are Default, WriteCacheEnabled, WriteCacheAndFUAEnabled
+	// and WriteCacheDisabled.
+	CacheAttributesKey = "cacheattributes"
+
+	// TagKeyPrefix contains the prefix of a volume parameter that designates it as
+	// a tag to be attached to the resource, e.g. tagSpecification_1: "key=value".
+	TagKeyPrefix = "tagspecification"
+
+	// FSBlockSizeKey configures the filesystem block size when formatting a volume.
+	FSBlockSizeKey = "fsblocksize"
 
 	// InodeSizeKey configures the inode size when formatting a volume.
 	InodeSizeKey = "inodesize"
@@ -89,6 +144,28 @@ const (
 	KubernetesPVNameKey = "csi.storage.k8s.io/pv/name"
 )
 
+// constants of values for the clone mode parameter.
+const (
+	// CloneModeCopy makes a full, independent copy of the source.
+	CloneModeCopy = "copy"
+
+	// CloneModeDifferencing creates a differencing VHD whose parent is the source snapshot.
+	CloneModeDifferencing = "differencing"
+)
+
+// constants of keys in snapshot parameters.
+const (
+	// VolumeSnapshotNameKey contains name of the snapshot.
+	VolumeSnapshotNameKey = "csi.storage.k8s.io/volumesnapshot/name"
+
+	// VolumeSnapshotNamespaceKey contains namespace of the snapshot.
+	VolumeSnapshotNamespaceKey = "csi.storage.k8s.io/volumesnapshot/namespace"
+
+	// VolumeSnapshotContentNameKey contains name of the VolumeSnapshotContent that is the source
+	// for the snapshot.
+	VolumeSnapshotContentNameKey = "csi.storage.k8s.io/volumesnapshotcontent/name"
+)
+
 // constants of keys in PublishContext.
 const (
 	// ControllerNumberKey represents key for the controller number to use when attaching the
@@ -98,6 +175,14 @@ const (
 	// ControllerLocationKey represents key for the controller location to use when attaching
 	// the virtual hard disk to the virtual machine.
 	ControllerLocationKey = "controllerLocation"
+
+	// CacheAttributesPublishKey represents key for the cache attributes the virtual hard disk
+	// was attached with.
+	CacheAttributesPublishKey = "cacheAttributes"
+
+	// DiskIdentifierKey represents key for the disk identifier of the virtual hard disk, which
+	// the node finds the disk by in the SCSI identifiers of its block devices.
+	DiskIdentifierKey = "diskIdentifier"
 )
 
 // constants of keys in VolumeContext.
@@ -123,7 +208,7 @@ var (
 	ValidFSTypes = map[string]struct{}{
 		FSTypeExt3: {},
 		FSTypeExt4: {},
-		// FSTypeXfs:  {},
+		FSTypeXfs:  {},
 		// FSTypeNtfs: {},
 	}
 )
@@ -142,8 +227,8 @@ var (
 	FileSystemConfigs = map[string]fileSystemConfig{
 		FSTypeExt3: {
 			NotSupportedParams: map[string]struct{}{
-				// Ext4BigAllocKey:    {},
-				// Ext4ClusterSizeKey: {},
+				Ext4BigAllocKey:    {},
+				Ext4ClusterSizeKey: {},
 			},
 		},
 		FSTypeExt4: {
@@ -151,20 +236,20 @@ var (
 		},
 		FSTypeXfs: {
 			NotSupportedParams: map[string]struct{}{
-				// BytesPerInodeKey:   {},
-				// NumberOfInodesKey:  {},
-				// Ext4BigAllocKey:    {},
-				// Ext4ClusterSizeKey: {},
+				BytesPerInodeKey:   {},
+				NumberOfInodesKey:  {},
+				Ext4BigAllocKey:    {},
+				Ext4ClusterSizeKey: {},
 			},
 		},
 		FSTypeNtfs: {
 			NotSupportedParams: map[string]struct{}{
-				// BlockSizeKey:       {},
-				// InodeSizeKey:       {},
-				// BytesPerInodeKey:   {},
-				// NumberOfInodesKey:  {},
-				// Ext4BigAllocKey:    {},
-				// Ext4ClusterSizeKey: {},
+				FSBlockSizeKey:     {},
+				InodeSizeKey:       {},
+				BytesPerInodeKey:   {},
+				NumberOfInodesKey:  {},
+				Ext4BigAllocKey:    {},
+				Ext4ClusterSizeKey: {},
 			},
 		},
 	}
@@ -2,7 +2,9 @@ package driver
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -15,6 +17,9 @@ import (
 	"github.com/nhduc2001kt/hyperv-csi-driver/pkg/util/template"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog/v2"
 )
 
@@ -29,10 +34,16 @@ var (
 	controllerCaps = []csi.ControllerServiceCapability_RPC_Type{
 		csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
 		csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
-		// csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
-		// csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
-		// csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
-		// csi.ControllerServiceCapability_RPC_MODIFY_VOLUME,
+		csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+		csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+		csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
+		csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+		csi.ControllerServiceCapability_RPC_GET_VOLUME,
+		csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES,
+		csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
+		csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+		csi.ControllerServiceCapability_RPC_MODIFY_VOLUME,
 	}
 )
 
@@ -41,20 +52,22 @@ var (
 
 // ControllerService represents the controller service of CSI driver.
 type ControllerService struct {
-	inFlight *internal.InFlight
-	options  *options.Options
-	cloud    cloud.Cloud
+	inFlight  *internal.InFlight
+	options   *options.Options
+	cloud     cloud.Cloud
+	k8sClient kubernetes.Interface
 	// modifyVolumeCoalescer coalescer.Coalescer[modifyVolumeRequest, int32]
 	// rpc.UnimplementedModifyServer
 	csi.UnimplementedControllerServer
 }
 
 // NewControllerService creates a new controller service.
-func NewControllerService(c cloud.Cloud, o *options.Options) *ControllerService {
+func NewControllerService(c cloud.Cloud, o *options.Options, k kubernetes.Interface) *ControllerService {
 	return &ControllerService{
-		cloud:    c,
-		options:  o,
-		inFlight: internal.NewInFlight(),
+		cloud:     c,
+		options:   o,
+		k8sClient: k,
+		inFlight:  internal.NewInFlight(),
 		// modifyVolumeCoalescer: newModifyVolumeCoalescer(c, o),
 	}
 }
@@ -88,11 +101,16 @@ func (d *ControllerService) ValidateVolumeCapabilities(ctx context.Context, req
 		return nil, status.Error(codes.InvalidArgument, "Volume capabilities not provided")
 	}
 
+	vol, err := parseVolumeID(volumeID)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "Volume %q not found: %v", volumeID, err)
+	}
 	input := &cloud.GetHyperVVHDInput{
-		Path: volumeID,
+		Host: vol.host,
+		Path: vol.path(),
 	}
 	if _, err := d.cloud.GetHyperVVHD(ctx, input); err != nil {
-		return nil, status.Errorf(codes.Internal, "Could not get volume with path %q: %v", volumeID, err)
+		return nil, status.Errorf(errorCode(err), "Could not get volume %q: %v", volumeID, err)
 	}
 
 	var confirmed *csi.ValidateVolumeCapabilitiesResponse_Confirmed
@@ -123,7 +141,6 @@ func (d *ControllerService) CreateVolume(ctx context.Context, req *csi.CreateVol
 			multiAttach = true
 		}
 	}
-	_ = multiAttach
 
 	// check if a request is already in-flight
 	if ok := d.inFlight.Insert(volName); !ok {
@@ -136,7 +153,16 @@ func (d *ControllerService) CreateVolume(ctx context.Context, req *csi.CreateVol
 		vhdType         = hyperv.VHDTypeFixed
 		vhdFormat       = hyperv.VHDFormatVHDX
 		vhdBlockSize    uint32
+		logicalSector   uint32
+		physicalSector  uint32
+		cloneMode       = CloneModeCopy
+		storagePath     string
+		parentVolume    *volumeID
+		cacheAttributes *hyperv.CacheAttributes
+		qosParameters   = &modifyVolumeRequest{}
 		tags            = map[string]string{}
+		scTags          []string
+		fsBlockSize     string
 		inodeSize       string
 		bytesPerInode   string
 		numberOfInodes  string
@@ -145,9 +171,12 @@ func (d *ControllerService) CreateVolume(ctx context.Context, req *csi.CreateVol
 	)
 
 	tProps := new(template.PVProps)
-	_ = tProps
 
 	for key, value := range req.GetParameters() {
+		if strings.HasPrefix(strings.ToLower(key), TagKeyPrefix) {
+			scTags = append(scTags, value)
+			continue
+		}
 		switch strings.ToLower(key) {
 		case VHDTypeKey:
 			vhdType, err = hyperv.StringToVHDType(value)
@@ -159,12 +188,54 @@ func (d *ControllerService) CreateVolume(ctx context.Context, req *csi.CreateVol
 			if err != nil {
 				return nil, status.Errorf(codes.InvalidArgument, "Could not parse invalid VHD format: %v", err)
 			}
+		case CloneModeKey:
+			switch mode := strings.ToLower(value); mode {
+			case CloneModeCopy, CloneModeDifferencing:
+				cloneMode = mode
+			default:
+				return nil, status.Errorf(codes.InvalidArgument, "Invalid clone mode %q", value)
+			}
+		case StoragePathKey:
+			storagePath = value
+		case ParentPathKey:
+			if parentVolume != nil {
+				return nil, status.Error(codes.InvalidArgument, "Only one of parentPath and parentVolumeId can be set")
+			}
+			parent := newVolumeID("", value)
+			parentVolume = &parent
+		case ParentVolumeIDKey:
+			if parentVolume != nil {
+				return nil, status.Error(codes.InvalidArgument, "Only one of parentPath and parentVolumeId can be set")
+			}
+			parent, err := parseVolumeID(value)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "Could not parse invalid parent volume ID: %v", err)
+			}
+			parentVolume = &parent
+		case CacheAttributesKey:
+			attributes, err := hyperv.StringToCacheAttributes(value)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "Could not parse invalid cache attributes %q: %v", value, err)
+			}
+			cacheAttributes = &attributes
+		case MaximumIopsKey, MinimumIopsKey, QosPolicyIDKey:
+			if err = qosParameters.setParameter(key, value); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "Invalid storage QoS parameter: %v", err)
+			}
 		case VHDBlockSizeKey:
 			parseBlockSizeKey, parseBlockSizeKeyErr := strconv.ParseInt(value, 10, 32)
 			if parseBlockSizeKeyErr != nil {
-				return nil, status.Errorf(codes.InvalidArgument, "Could not parse invalid block size: %v", err)
+				return nil, status.Errorf(codes.InvalidArgument, "Could not parse invalid block size: %v", parseBlockSizeKeyErr)
 			}
 			vhdBlockSize = uint32(parseBlockSizeKey)
+		case LogicalSectorSizeKey:
+			if logicalSector, err = parseSectorSize(key, value); err != nil {
+				return nil, err
+			}
+		case PhysicalSectorSizeKey:
+			if physicalSector, err = parseSectorSize(key, value); err != nil {
+				return nil, err
+			}
 		case KubernetesPVCNameKey:
 			tags[PVCNameTag] = value
 			tProps.PVCName = value
@@ -174,26 +245,31 @@ func (d *ControllerService) CreateVolume(ctx context.Context, req *csi.CreateVol
 		case KubernetesPVNameKey:
 			tags[PVNameTag] = value
 			tProps.PVName = value
+		case FSBlockSizeKey:
+			if isAlphanumeric := util.StringIsAlphanumeric(value); !isAlphanumeric {
+				return nil, status.Errorf(codes.InvalidArgument, "Invalid fsBlockSize %q: the value must be alphanumeric", value)
+			}
+			fsBlockSize = value
 		case InodeSizeKey:
 			if isAlphanumeric := util.StringIsAlphanumeric(value); !isAlphanumeric {
-				return nil, status.Errorf(codes.InvalidArgument, "Could not parse inodeSize (%s): %v", value, err)
+				return nil, status.Errorf(codes.InvalidArgument, "Invalid inodeSize %q: the value must be alphanumeric", value)
 			}
 			inodeSize = value
 		case BytesPerInodeKey:
 			if isAlphanumeric := util.StringIsAlphanumeric(value); !isAlphanumeric {
-				return nil, status.Errorf(codes.InvalidArgument, "Could not parse bytesPerInode (%s): %v", value, err)
+				return nil, status.Errorf(codes.InvalidArgument, "Invalid bytesPerInode %q: the value must be alphanumeric", value)
 			}
 			bytesPerInode = value
 		case NumberOfInodesKey:
 			if isAlphanumeric := util.StringIsAlphanumeric(value); !isAlphanumeric {
-				return nil, status.Errorf(codes.InvalidArgument, "Could not parse numberOfInodes (%s): %v", value, err)
+				return nil, status.Errorf(codes.InvalidArgument, "Invalid numberOfInodes %q: the value must be alphanumeric", value)
 			}
 			numberOfInodes = value
 		case Ext4BigAllocKey:
 			ext4BigAlloc = util.IsTrue(value)
 		case Ext4ClusterSizeKey:
 			if isAlphanumeric := util.StringIsAlphanumeric(value); !isAlphanumeric {
-				return nil, status.Errorf(codes.InvalidArgument, "Could not parse ext4ClusterSize (%s): %v", value, err)
+				return nil, status.Errorf(codes.InvalidArgument, "Invalid ext4ClusterSize %q: the value must be alphanumeric", value)
 			}
 			ext4ClusterSize = value
 		default:
@@ -201,33 +277,118 @@ func (d *ControllerService) CreateVolume(ctx context.Context, req *csi.CreateVol
 		}
 	}
 
-	// TODO: treat Mutable Parameters
-	_, err = parseModifyVolumeParameters(req.GetMutableParameters())
+	// Mutable parameters of a VolumeAttributesClass override the ones of the StorageClass
+	mutableParameters, err := parseModifyVolumeParameters(req.GetMutableParameters())
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "Invalid mutable parameter: %v", err)
 	}
+	qos := mutableParameters.apply(qosParameters.apply(cloud.HyperVVHDQoS{}))
+	if err = validateQoS(qos); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid storage QoS: %v", err)
+	}
 
-	snapshotID := ""
-	volumeSource := req.GetVolumeContentSource()
+	var (
+		sourceHost   string
+		sourcePath   string
+		parentPath   string
+		volumeSource = req.GetVolumeContentSource()
+	)
 	if volumeSource != nil {
-		if _, ok := volumeSource.GetType().(*csi.VolumeContentSource_Snapshot); !ok {
-			return nil, status.Error(codes.InvalidArgument, "Unsupported volumeContentSource type")
+		sourceVolume, err := d.getVolumeContentSource(ctx, volumeSource)
+		if err != nil {
+			return nil, err
+		}
+		sourceHost = sourceVolume.host
+		sourcePath = sourceVolume.path()
+
+		source, err := d.cloud.GetHyperVVHD(ctx, &cloud.GetHyperVVHDInput{Host: sourceHost, Path: sourcePath})
+		if err != nil {
+			if errors.Is(err, cloud.ErrNotFound) {
+				return nil, status.Errorf(codes.NotFound, "Volume content source %q not found", sourcePath)
+			}
+			return nil, status.Errorf(errorCode(err), "Could not get volume content source %q: %v", sourcePath, err)
+		}
+		if source.Format == hyperv.VHDFormatVHDSet {
+			return nil, status.Errorf(codes.InvalidArgument, "Volume content source %q is a VHD Set, which cannot be cloned", sourcePath)
+		}
+		if int64(source.Size) > volSizeBytes {
+			return nil, status.Errorf(codes.OutOfRange, "Requested size %d is smaller than the size %d of volume content source %q", volSizeBytes, source.Size, sourcePath)
+		}
+		// The new VHD keeps the format and the sector sizes of its source
+		vhdFormat = source.Format
+		if err = inheritSectorSizes(&logicalSector, &physicalSector, source, sourcePath); err != nil {
+			return nil, err
+		}
+
+		switch cloneMode {
+		case CloneModeCopy:
+			// A copy also keeps the type of its source
+			vhdType = source.Type
+		case CloneModeDifferencing:
+			// The parent of a differencing VHD must never change, which only snapshots guarantee
+			if volumeSource.GetSnapshot() == nil {
+				return nil, status.Errorf(codes.InvalidArgument, "Clone mode %s is only supported for snapshot sources", cloneMode)
+			}
+			vhdType = hyperv.VHDTypeDifferencing
+			parentPath = sourcePath
+			sourcePath = ""
+		}
+	}
+
+	if parentVolume != nil {
+		if volumeSource != nil {
+			return nil, status.Error(codes.InvalidArgument, "A parent volume cannot be combined with a volume content source")
 		}
-		sourceSnapshot := volumeSource.GetSnapshot()
-		if sourceSnapshot == nil {
-			return nil, status.Error(codes.InvalidArgument, "Error retrieving snapshot from the volumeContentSource")
+		parent, err := d.getParentVolume(ctx, *parentVolume, volSizeBytes)
+		if err != nil {
+			return nil, err
+		}
+		// A differencing VHD has the format and the sector sizes of its parent
+		vhdType = hyperv.VHDTypeDifferencing
+		vhdFormat = parent.Format
+		sourceHost = parentVolume.host
+		parentPath = parentVolume.path()
+		if err = inheritSectorSizes(&logicalSector, &physicalSector, parent, parentPath); err != nil {
+			return nil, err
 		}
-		snapshotID = sourceSnapshot.GetSnapshotId()
 	}
-	_ = snapshotID
 
-	// TODO: handle Accessibility Requirements
+	if logicalSector > 0 && physicalSector > 0 && physicalSector < logicalSector {
+		return nil, status.Errorf(codes.InvalidArgument, "Physical sector size %d is smaller than logical sector size %d", physicalSector, logicalSector)
+	}
+	if vhdFormat == hyperv.VHDFormatVHD && logicalSector > 512 {
+		return nil, status.Errorf(codes.InvalidArgument, "The %s format only supports 512-byte logical sectors", vhdFormat)
+	}
+
+	// Only VHD Sets and shared VHDX files can be attached to several VMs
+	if multiAttach {
+		if vhdFormat != hyperv.VHDFormatVHDX && vhdFormat != hyperv.VHDFormatVHDSet {
+			return nil, status.Errorf(codes.InvalidArgument, "Multi-attach requires the %s or %s format, not %s", hyperv.VHDFormatVHDX, hyperv.VHDFormatVHDSet, vhdFormat)
+		}
+		if vhdType == hyperv.VHDTypeDifferencing {
+			return nil, status.Errorf(codes.InvalidArgument, "Multi-attach is not supported for %s VHDs", vhdType)
+		}
+	}
 
 	responseCtx := map[string]string{}
 
+	if cacheAttributes != nil {
+		responseCtx[CacheAttributesKey] = cacheAttributes.String()
+	}
+	// Nodes match the filesystem to the sector sizes of the disk
+	if logicalSector > 0 {
+		responseCtx[LogicalSectorSizeKey] = strconv.Itoa(int(logicalSector))
+	}
+	if physicalSector > 0 {
+		responseCtx[PhysicalSectorSizeKey] = strconv.Itoa(int(physicalSector))
+	}
+	// The block size of the VHD is no formatting option, filesystem blocks are much smaller
 	if vhdBlockSize > 0 {
 		responseCtx[VHDBlockSizeKey] = strconv.Itoa(int(vhdBlockSize))
-		if err = validateFormattingOption(volCap, VHDBlockSizeKey, FileSystemConfigs); err != nil {
+	}
+	if len(fsBlockSize) > 0 {
+		responseCtx[FSBlockSizeKey] = fsBlockSize
+		if err = validateFormattingOption(volCap, FSBlockSizeKey, FileSystemConfigs); err != nil {
 			return nil, err
 		}
 	}
@@ -262,6 +423,24 @@ func (d *ControllerService) CreateVolume(ctx context.Context, req *csi.CreateVol
 		}
 	}
 
+	// Tags set by users are added first, so that they cannot override the tags of the driver
+	extraTags := make([]string, 0, len(d.options.ExtraTags))
+	for k, v := range d.options.ExtraTags {
+		extraTags = append(extraTags, k+"="+v)
+	}
+	addTags, err := template.Evaluate(append(extraTags, scTags...), tProps, d.options.WarnOnInvalidTag)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Error interpolating the tag value: %v", err)
+	}
+	if err = validateExtraTags(addTags, d.options.WarnOnInvalidTag); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid tag value: %v", err)
+	}
+	for k, v := range addTags {
+		if _, ok := tags[k]; !ok {
+			tags[k] = v
+		}
+	}
+
 	// Fill volume tags
 	if d.options.KubernetesClusterID != "" {
 		resourceLifecycleTag := ResourceLifecycleTagPrefix + d.options.KubernetesClusterID
@@ -270,36 +449,62 @@ func (d *ControllerService) CreateVolume(ctx context.Context, req *csi.CreateVol
 		tags[KubernetesClusterTag] = d.options.KubernetesClusterID
 	}
 
-	// TODO: validate tags
-
 	input := &cloud.CreateHyperVVHDInput{
-		Name: volName,
-		// Source:             snapshotID,
+		Name:       volName,
+		Directory:  storagePath,
+		Hosts:      getTopologyHosts(req.GetAccessibilityRequirements()),
+		SourceHost: sourceHost,
+		Source:     sourcePath,
 		// SourceVm:           sourceVm,
 		// SourceDisk:         sourceDisk,
-		Type: vhdType,
-		// ParentPath:         parentPath,
-		Size:      uint64(volSizeBytes),
-		BlockSize: vhdBlockSize,
-		Format:    vhdFormat,
-		// LogicalSectorSize:  logicalSectorSize,
-		// PhysicalSectorSize: physicalSectorSize,
+		Type:               vhdType,
+		ParentPath:         parentPath,
+		Size:               uint64(volSizeBytes),
+		BlockSize:          vhdBlockSize,
+		LogicalSectorSize:  logicalSector,
+		PhysicalSectorSize: physicalSector,
+		Format:             vhdFormat,
+		QoS:                qos,
+		Tags:               tags,
 	}
 	output, err := d.cloud.CreateHyperVVHD(ctx, input)
 	if err != nil {
-		var errCode codes.Code
-		switch {
-		// TODO: handle error
-		// case errors.Is(err, cloud.ErrNotFound):
-		// 	errCode = codes.NotFound
-		// case errors.Is(err, cloud.ErrIdempotentParameterMismatch), errors.Is(err, cloud.ErrAlreadyExists):
-		// 	errCode = codes.AlreadyExists
-		default:
-			errCode = codes.Internal
+		return nil, status.Errorf(errorCode(err), "Could not create volume %q: %v", volName, err)
+	}
+	klog.V(4).InfoS("CreateVolume: created VHD", "path", output.Path, "size", output.Size, "fileSize", output.FileSize)
+	return newCreateVolumeResponse(output, volumeSource, responseCtx), nil
+}
+
+// getParentVolume returns the VHD of vol if differencing volumes of volSizeBytes can be created
+// from it.
+func (d *ControllerService) getParentVolume(ctx context.Context, vol volumeID, volSizeBytes int64) (*cloud.GetHyperVVHDOutput, error) {
+	path := vol.path()
+	parent, err := d.cloud.GetHyperVVHD(ctx, &cloud.GetHyperVVHDInput{Host: vol.host, Path: path})
+	if err != nil {
+		if errors.Is(err, cloud.ErrNotFound) {
+			return nil, status.Errorf(codes.InvalidArgument, "Parent volume %q not found", path)
+		}
+		return nil, status.Errorf(errorCode(err), "Could not get parent volume %q: %v", path, err)
+	}
+	if parent.Format == hyperv.VHDFormatVHDSet {
+		return nil, status.Errorf(codes.InvalidArgument, "Parent volume %q is a VHD Set, which cannot have differencing children", path)
+	}
+	if int64(parent.Size) > volSizeBytes {
+		return nil, status.Errorf(codes.OutOfRange, "Requested size %d is smaller than the size %d of parent volume %q", volSizeBytes, parent.Size, path)
+	}
+
+	// Every child would be corrupted by a VM writing to the parent
+	output, err := d.cloud.ListHyperVVHDs(ctx, &cloud.ListHyperVVHDsInput{Host: vol.host, Path: path})
+	if err != nil {
+		return nil, status.Errorf(errorCode(err), "Could not get attachments of parent volume %q: %v", path, err)
+	}
+	for _, vhd := range output.VHDs {
+		if len(vhd.VmIDs) > 0 {
+			return nil, status.Errorf(codes.FailedPrecondition, "Parent volume %q is attached to VM %s", path, vhd.VmIDs[0])
 		}
-		return nil, status.Errorf(errCode, "Could not create volume %q: %v", volName, err)
 	}
-	return newCreateVolumeResponse(output), nil
+
+	return parent, nil
 }
 
 func (d *ControllerService) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
@@ -317,21 +522,135 @@ func (d *ControllerService) DeleteVolume(ctx context.Context, req *csi.DeleteVol
 	}
 	defer d.inFlight.Delete(volumeID)
 
+	vol, err := parseVolumeID(volumeID)
+	if err != nil {
+		klog.V(4).InfoS("DeleteVolume: volume ID is invalid, returning with success", "volumeID", volumeID, "err", err)
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+
 	input := &cloud.DeleteHyperVVHDInput{
-		Path: volumeID,
+		Host: vol.host,
+		Path: vol.path(),
+	}
+	// Volumes of other clusters are never deleted. Only volumes older than versioned IDs may
+	// predate tags, and only if the cluster says so.
+	if d.options.KubernetesClusterID != "" {
+		input.OwnerTags = map[string]string{
+			ResourceLifecycleTagPrefix + d.options.KubernetesClusterID: ResourceLifecycleOwned,
+		}
+		input.AllowUntagged = vol.legacy && d.options.DeleteUntaggedLegacyVolumes
 	}
 	if _, err := d.cloud.DeleteHyperVVHD(ctx, input); err != nil {
-		// if errors.Is(err, cloud.ErrNotFound) {
-		// 	klog.V(4).InfoS("DeleteVolume: volume not found, returning with success")
-		// 	return &csi.DeleteVolumeResponse{}, nil
-		// }
-		// TODO: handle error not found
-		return nil, status.Errorf(codes.Internal, "Could not delete volume path %q: %v", volumeID, err)
+		if errors.Is(err, cloud.ErrNotFound) {
+			klog.V(4).InfoS("DeleteVolume: volume not found, returning with success")
+			return &csi.DeleteVolumeResponse{}, nil
+		}
+		return nil, status.Errorf(errorCode(err), "Could not delete volume %q: %v", volumeID, err)
 	}
 
 	return &csi.DeleteVolumeResponse{}, nil
 }
 
+func (d *ControllerService) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	klog.V(4).InfoS("ControllerExpandVolume: called", "args", util.SanitizeRequest(req))
+	if err := validateControllerExpandVolumeRequest(req); err != nil {
+		return nil, err
+	}
+
+	volumeID := req.GetVolumeId()
+	capRange := req.GetCapacityRange()
+	newSize := util.RoundUpBytes(capRange.GetRequiredBytes())
+	maxVolSize := capRange.GetLimitBytes()
+	if maxVolSize > 0 && maxVolSize < newSize {
+		return nil, status.Error(codes.InvalidArgument, "After round-up, volume size exceeds the limit specified")
+	}
+
+	// check if a request is already in-flight
+	if ok := d.inFlight.Insert(volumeID); !ok {
+		msg := fmt.Sprintf(internal.VolumeOperationAlreadyExistsErrorMsg, volumeID)
+		return nil, status.Error(codes.Aborted, msg)
+	}
+	defer d.inFlight.Delete(volumeID)
+
+	vol, err := parseVolumeID(volumeID)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "Volume %q not found: %v", volumeID, err)
+	}
+
+	input := &cloud.ExpandHyperVVHDInput{
+		Host: vol.host,
+		Path: vol.path(),
+		Size: uint64(newSize),
+	}
+	output, err := d.cloud.ExpandHyperVVHD(ctx, input)
+	if err != nil {
+		return nil, status.Errorf(errorCode(err), "Could not resize volume %q: %v", volumeID, err)
+	}
+
+	// Block volumes are used as is, only filesystems have to be grown on the node
+	nodeExpansionRequired := true
+	if volCap := req.GetVolumeCapability(); volCap != nil && isBlock(volCap) {
+		nodeExpansionRequired = false
+	}
+
+	return &csi.ControllerExpandVolumeResponse{
+		CapacityBytes:         int64(output.Size),
+		NodeExpansionRequired: nodeExpansionRequired,
+	}, nil
+}
+
+func (d *ControllerService) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
+	klog.V(4).InfoS("GetCapacity: called", "args", util.SanitizeRequest(req))
+
+	var (
+		err         error
+		vhdType     = hyperv.VHDTypeFixed
+		storagePath string
+		hasParent   bool
+	)
+	// Other parameters do not change the space a volume takes
+	for key, value := range req.GetParameters() {
+		switch strings.ToLower(key) {
+		case VHDTypeKey:
+			vhdType, err = hyperv.StringToVHDType(value)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "Could not parse invalid VHD type: %v", err)
+			}
+		case StoragePathKey:
+			storagePath = value
+		case ParentPathKey, ParentVolumeIDKey:
+			hasParent = true
+		}
+	}
+	// Volumes with a parent are differencing VHDs whatever their type parameter
+	if hasParent {
+		vhdType = hyperv.VHDTypeDifferencing
+	}
+
+	input := &cloud.GetHyperVCapacityInput{
+		Host:      req.GetAccessibleTopology().GetSegments()[TopologyKey],
+		Directory: storagePath,
+	}
+	output, err := d.cloud.GetHyperVCapacity(ctx, input)
+	if err != nil {
+		if errors.Is(err, cloud.ErrNotFound) {
+			klog.V(4).InfoS("GetCapacity: Hyper-V host is not registered", "host", input.Host)
+			return &csi.GetCapacityResponse{}, nil
+		}
+		return nil, status.Errorf(errorCode(err), "Could not get capacity: %v", err)
+	}
+
+	available := int64(output.AvailableBytes)
+	// Dynamic and differencing VHDs only take the space that is written to
+	if vhdType != hyperv.VHDTypeFixed {
+		available = int64(float64(available) * d.options.DynamicVHDOvercommitRatio)
+	}
+
+	return &csi.GetCapacityResponse{
+		AvailableCapacity: available,
+	}, nil
+}
+
 func (d *ControllerService) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
 	klog.V(4).InfoS("ControllerPublishVolume: called", "args", util.SanitizeRequest(req))
 	if err := validateControllerPublishVolumeRequest(req); err != nil {
@@ -346,18 +665,38 @@ func (d *ControllerService) ControllerPublishVolume(ctx context.Context, req *cs
 	}
 	defer d.inFlight.Delete(volumeID + nodeID)
 
+	vol, err := parseVolumeID(volumeID)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "Volume %q not found: %v", volumeID, err)
+	}
+
+	cacheAttributes := hyperv.CacheAttributesDefault
+	if value, ok := req.GetVolumeContext()[CacheAttributesKey]; ok {
+		cacheAttributes, err = hyperv.StringToCacheAttributes(value)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "Could not parse invalid cache attributes %q of volume %q: %v", value, volumeID, err)
+		}
+	}
+
 	input := cloud.AttachHyperVVHDInput{
-		VmID:    nodeID,
-		VHDPath: volumeID,
+		VmID:                          nodeID,
+		Host:                          vol.host,
+		VHDPath:                       vol.path(),
+		SupportPersistentReservations: req.GetVolumeCapability().GetAccessMode().GetMode() == MultiNodeMultiWriter,
+		CacheAttributes:               cacheAttributes,
 	}
 	output, err := d.cloud.AttachHyperVVHD(ctx, &input)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Could not attach volume %q to node %q: %v", volumeID, nodeID, err)
+		return nil, status.Errorf(errorCode(err), "Could not attach volume %q to node %q: %v", volumeID, nodeID, err)
 	}
 
 	pvInfo := map[string]string{
-		ControllerNumberKey:   strconv.Itoa(int(output.ControllerNumber)),
-		ControllerLocationKey: strconv.Itoa(int(output.ControllerLocation)),
+		ControllerNumberKey:       strconv.Itoa(int(output.ControllerNumber)),
+		ControllerLocationKey:     strconv.Itoa(int(output.ControllerLocation)),
+		CacheAttributesPublishKey: output.CacheAttributes.String(),
+	}
+	if output.DiskIdentifier != "" {
+		pvInfo[DiskIdentifierKey] = output.DiskIdentifier
 	}
 	return &csi.ControllerPublishVolumeResponse{PublishContext: pvInfo}, nil
 }
@@ -377,14 +716,25 @@ func (d *ControllerService) ControllerUnpublishVolume(ctx context.Context, req *
 	}
 	defer d.inFlight.Delete(volumeID + nodeID)
 
+	vol, err := parseVolumeID(volumeID)
+	if err != nil {
+		klog.InfoS("ControllerUnpublishVolume: volume ID is invalid, returning with success", "volumeID", volumeID, "err", err)
+		return &csi.ControllerUnpublishVolumeResponse{}, nil
+	}
+
 	klog.V(2).InfoS("ControllerUnpublishVolume: detaching", "volumeID", volumeID, "nodeID", nodeID)
 	input := cloud.DetachHyperVVHDInput{
 		VmID:    nodeID,
-		VHDPath: volumeID,
+		Host:    vol.host,
+		VHDPath: vol.path(),
 	}
 	output, err := d.cloud.DetachHyperVVHD(ctx, &input)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Could not attach volume %q to node %q: %v", volumeID, nodeID, err)
+		if errors.Is(err, cloud.ErrNotFound) {
+			klog.InfoS("ControllerUnpublishVolume: volume or node not found, returning with success", "volumeID", volumeID, "nodeID", nodeID)
+			return &csi.ControllerUnpublishVolumeResponse{}, nil
+		}
+		return nil, status.Errorf(errorCode(err), "Could not detach volume %q from node %q: %v", volumeID, nodeID, err)
 	}
 	if output == nil {
 		klog.InfoS("ControllerUnpublishVolume: attachment not found", "volumeID", volumeID, "nodeID", nodeID)
@@ -393,38 +743,424 @@ func (d *ControllerService) ControllerUnpublishVolume(ctx context.Context, req *
 	return &csi.ControllerUnpublishVolumeResponse{}, nil
 }
 
-func newCreateVolumeResponse(output *cloud.CreateHyperVVHDOutput) *csi.CreateVolumeResponse {
-	var src *csi.VolumeContentSource
-	// if output.SnapshotID != "" {
-	// 	src = &csi.VolumeContentSource{
-	// 		Type: &csi.VolumeContentSource_Snapshot{
-	// 			Snapshot: &csi.VolumeContentSource_SnapshotSource{
-	// 				SnapshotId: disk.SnapshotID,
-	// 			},
-	// 		},
-	// 	}
-	// }
-	// TODO: handle snapshot
+func (d *ControllerService) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
+	klog.V(4).InfoS("ListVolumes: called", "args", util.SanitizeRequest(req))
+
+	maxEntries := int(req.GetMaxEntries())
+	if maxEntries < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid max entries %d", maxEntries)
+	}
+
+	start := 0
+	if token := req.GetStartingToken(); token != "" {
+		var err error
+		start, err = strconv.Atoi(token)
+		if err != nil || start < 0 {
+			return nil, status.Errorf(codes.Aborted, "Invalid starting token %q", token)
+		}
+	}
+
+	output, err := d.cloud.ListHyperVVHDs(ctx, &cloud.ListHyperVVHDsInput{})
+	if err != nil {
+		return nil, status.Errorf(errorCode(err), "Could not list volumes: %v", err)
+	}
+
+	vhds := output.VHDs
+	if start > len(vhds) {
+		return nil, status.Errorf(codes.Aborted, "Starting token %d is out of range", start)
+	}
+
+	// Keep the order stable between pages
+	sort.Slice(vhds, func(i, j int) bool {
+		return vhds[i].Path < vhds[j].Path
+	})
+
+	end := len(vhds)
+	nextToken := ""
+	if maxEntries > 0 && start+maxEntries < end {
+		end = start + maxEntries
+		nextToken = strconv.Itoa(end)
+	}
+
+	nodeIDs := d.getNodeIDs(ctx)
+	entries := make([]*csi.ListVolumesResponse_Entry, 0, end-start)
+	for i := range vhds[start:end] {
+		vhd := &vhds[start+i]
+		entries = append(entries, &csi.ListVolumesResponse_Entry{
+			Volume: newCSIVolume(vhd),
+			Status: &csi.ListVolumesResponse_VolumeStatus{
+				PublishedNodeIds: vhd.VmIDs,
+				VolumeCondition:  getVolumeCondition(vhd, nodeIDs),
+			},
+		})
+	}
+
+	return &csi.ListVolumesResponse{
+		Entries:   entries,
+		NextToken: nextToken,
+	}, nil
+}
+
+func (d *ControllerService) ControllerGetVolume(ctx context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
+	klog.V(4).InfoS("ControllerGetVolume: called", "args", util.SanitizeRequest(req))
+
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID not provided")
+	}
+
+	vol, err := parseVolumeID(volumeID)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "Volume %q not found: %v", volumeID, err)
+	}
+
+	output, err := d.cloud.ListHyperVVHDs(ctx, &cloud.ListHyperVVHDsInput{Host: vol.host, Path: vol.path()})
+	if err != nil {
+		return nil, status.Errorf(errorCode(err), "Could not get volume %q: %v", volumeID, err)
+	}
+	if len(output.VHDs) == 0 {
+		return nil, status.Errorf(codes.NotFound, "Volume %q not found", volumeID)
+	}
+
+	vhd := &output.VHDs[0]
+	return &csi.ControllerGetVolumeResponse{
+		Volume: newCSIVolume(vhd),
+		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
+			PublishedNodeIds: vhd.VmIDs,
+			VolumeCondition:  getVolumeCondition(vhd, d.getNodeIDs(ctx)),
+		},
+	}, nil
+}
+
+// getNodeIDs returns the IDs of the nodes the driver runs on, or nil if they are unknown.
+func (d *ControllerService) getNodeIDs(ctx context.Context) map[string]bool {
+	if d.k8sClient == nil {
+		return nil
+	}
+
+	csiNodes, err := d.k8sClient.StorageV1().CSINodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		klog.ErrorS(err, "Could not list CSI nodes, skipping the check of VM attachments")
+		return nil
+	}
+
+	nodeIDs := map[string]bool{}
+	for _, csiNode := range csiNodes.Items {
+		for _, driver := range csiNode.Spec.Drivers {
+			if driver.Name == DriverName {
+				nodeIDs[strings.ToUpper(driver.NodeID)] = true
+			}
+		}
+	}
+	return nodeIDs
+}
+
+// getVolumeCondition reports the problems of a VHD seen from the Hyper-V host.
+func getVolumeCondition(vhd *cloud.HyperVVHD, nodeIDs map[string]bool) *csi.VolumeCondition {
+	if vhd.Missing {
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("VHD file %s does not exist, but VMs %v still reference it", vhd.Path, vhd.VmIDs),
+		}
+	}
+	if !vhd.ParentChainValid {
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("Parent chain of differencing VHD %s is broken, parent %s is missing or was modified", vhd.Path, vhd.ParentPath),
+		}
+	}
+	if vhd.Attached && len(vhd.VmIDs) == 0 {
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("VHD %s is mounted outside of any VM, e.g. on the Hyper-V host", vhd.Path),
+		}
+	}
+	if nodeIDs != nil {
+		for _, vmID := range vhd.VmIDs {
+			if !nodeIDs[strings.ToUpper(vmID)] {
+				return &csi.VolumeCondition{
+					Abnormal: true,
+					Message:  fmt.Sprintf("VHD %s is attached to VM %s, which is not a node of the cluster", vhd.Path, vmID),
+				}
+			}
+		}
+	}
+
+	return &csi.VolumeCondition{}
+}
+
+func newCSIVolume(vhd *cloud.HyperVVHD) *csi.Volume {
+	volume := &csi.Volume{
+		VolumeId:      newVolumeID(vhd.Host, vhd.Path).String(),
+		CapacityBytes: int64(vhd.Size),
+	}
+	if vhd.Host != "" {
+		volume.AccessibleTopology = []*csi.Topology{
+			{
+				Segments: map[string]string{TopologyKey: vhd.Host},
+			},
+		}
+	}
+	return volume
+}
+
+func (d *ControllerService) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	klog.V(4).InfoS("CreateSnapshot: called", "args", util.SanitizeRequest(req))
+	if err := validateCreateSnapshotRequest(req); err != nil {
+		return nil, err
+	}
+
+	snapshotName := req.GetName()
+	volumeID := req.GetSourceVolumeId()
+
+	// check if a request is already in-flight
+	if ok := d.inFlight.Insert(snapshotName); !ok {
+		msg := fmt.Sprintf("Create snapshot request for %s is already in progress", snapshotName)
+		return nil, status.Error(codes.Aborted, msg)
+	}
+	defer d.inFlight.Delete(snapshotName)
+
+	for key := range req.GetParameters() {
+		switch key {
+		case VolumeSnapshotNameKey, VolumeSnapshotNamespaceKey, VolumeSnapshotContentNameKey:
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "Invalid parameter key %s for CreateSnapshot", key)
+		}
+	}
+
+	vol, err := parseVolumeID(volumeID)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "Volume %q not found: %v", volumeID, err)
+	}
+
+	input := &cloud.CreateHyperVVHDSnapshotInput{
+		Name:       snapshotName,
+		SourceHost: vol.host,
+		SourcePath: vol.path(),
+	}
+	output, err := d.cloud.CreateHyperVVHDSnapshot(ctx, input)
+	if err != nil {
+		return nil, status.Errorf(errorCode(err), "Could not create snapshot %q of volume %q: %v", snapshotName, volumeID, err)
+	}
+
+	return &csi.CreateSnapshotResponse{
+		Snapshot: newCSISnapshot(&output.HyperVVHDSnapshot),
+	}, nil
+}
+
+func (d *ControllerService) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	klog.V(4).InfoS("DeleteSnapshot: called", "args", util.SanitizeRequest(req))
+	if err := validateDeleteSnapshotRequest(req); err != nil {
+		return nil, err
+	}
+
+	snapshotID := req.GetSnapshotId()
+
+	// check if a request is already in-flight
+	if ok := d.inFlight.Insert(snapshotID); !ok {
+		msg := fmt.Sprintf("DeleteSnapshot for Snapshot %s is already in progress", snapshotID)
+		return nil, status.Error(codes.Aborted, msg)
+	}
+	defer d.inFlight.Delete(snapshotID)
+
+	snapshot, err := parseVolumeID(snapshotID)
+	if err != nil {
+		klog.V(4).InfoS("DeleteSnapshot: snapshot ID is invalid, returning with success", "snapshotID", snapshotID, "err", err)
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+
+	input := &cloud.DeleteHyperVVHDSnapshotInput{
+		Host: snapshot.host,
+		Path: snapshot.path(),
+	}
+	if _, err := d.cloud.DeleteHyperVVHDSnapshot(ctx, input); err != nil {
+		if errors.Is(err, cloud.ErrNotFound) {
+			klog.V(4).InfoS("DeleteSnapshot: snapshot not found, returning with success")
+			return &csi.DeleteSnapshotResponse{}, nil
+		}
+		return nil, status.Errorf(errorCode(err), "Could not delete snapshot path %q: %v", snapshotID, err)
+	}
+
+	return &csi.DeleteSnapshotResponse{}, nil
+}
+
+func (d *ControllerService) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
+	klog.V(4).InfoS("ListSnapshots: called", "args", util.SanitizeRequest(req))
 
-	segments := map[string]string{
-		// WellKnownZoneTopologyKey: disk.AvailabilityZone
+	maxEntries := int(req.GetMaxEntries())
+	if maxEntries < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid max entries %d", maxEntries)
+	}
+
+	start := 0
+	if token := req.GetStartingToken(); token != "" {
+		var err error
+		start, err = strconv.Atoi(token)
+		if err != nil || start < 0 {
+			return nil, status.Errorf(codes.Aborted, "Invalid starting token %q", token)
+		}
+	}
+
+	input := &cloud.ListHyperVVHDSnapshotsInput{}
+	if snapshotID := req.GetSnapshotId(); snapshotID != "" {
+		snapshot, err := parseVolumeID(snapshotID)
+		if err != nil {
+			klog.V(4).InfoS("ListSnapshots: snapshot ID is invalid, returning no snapshots", "snapshotID", snapshotID, "err", err)
+			return &csi.ListSnapshotsResponse{}, nil
+		}
+		input.Host = snapshot.host
+		input.SnapshotPath = snapshot.path()
+	} else if volumeID := req.GetSourceVolumeId(); volumeID != "" {
+		vol, err := parseVolumeID(volumeID)
+		if err != nil {
+			klog.V(4).InfoS("ListSnapshots: source volume ID is invalid, returning no snapshots", "volumeID", volumeID, "err", err)
+			return &csi.ListSnapshotsResponse{}, nil
+		}
+		input.Host = vol.host
+		input.SourcePath = vol.path()
+	}
+	output, err := d.cloud.ListHyperVVHDSnapshots(ctx, input)
+	if err != nil {
+		return nil, status.Errorf(errorCode(err), "Could not list snapshots: %v", err)
+	}
+
+	snapshots := output.Snapshots
+	if start > len(snapshots) {
+		return nil, status.Errorf(codes.Aborted, "Starting token %d is out of range", start)
+	}
+
+	// Keep the order stable between pages
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Path < snapshots[j].Path
+	})
+
+	end := len(snapshots)
+	nextToken := ""
+	if maxEntries > 0 && start+maxEntries < end {
+		end = start + maxEntries
+		nextToken = strconv.Itoa(end)
+	}
+
+	entries := make([]*csi.ListSnapshotsResponse_Entry, 0, end-start)
+	for i := range snapshots[start:end] {
+		entries = append(entries, &csi.ListSnapshotsResponse_Entry{
+			Snapshot: newCSISnapshot(&snapshots[start+i]),
+		})
+	}
+
+	return &csi.ListSnapshotsResponse{
+		Entries:   entries,
+		NextToken: nextToken,
+	}, nil
+}
+
+func newCSISnapshot(snapshot *cloud.HyperVVHDSnapshot) *csi.Snapshot {
+	return &csi.Snapshot{
+		SnapshotId:     newVolumeID(snapshot.Host, snapshot.Path).String(),
+		SourceVolumeId: newVolumeID(snapshot.Host, snapshot.SourcePath).String(),
+		SizeBytes:      int64(snapshot.Size),
+		CreationTime:   timestamppb.New(snapshot.CreationTime),
+		// VHD copies are taken synchronously, so they are usable as soon as they exist
+		ReadyToUse: true,
+	}
+}
+
+// getVolumeContentSource returns the ID of the VHD a volume is created from.
+func (d *ControllerService) getVolumeContentSource(ctx context.Context, volumeSource *csi.VolumeContentSource) (volumeID, error) {
+	switch source := volumeSource.GetType().(type) {
+	case *csi.VolumeContentSource_Snapshot:
+		snapshotID := source.Snapshot.GetSnapshotId()
+		if len(snapshotID) == 0 {
+			return volumeID{}, status.Error(codes.InvalidArgument, "Error retrieving snapshot from the volumeContentSource")
+		}
+		snapshot, err := parseVolumeID(snapshotID)
+		if err != nil {
+			return volumeID{}, status.Errorf(codes.NotFound, "Snapshot %q not found: %v", snapshotID, err)
+		}
+		output, err := d.cloud.ListHyperVVHDSnapshots(ctx, &cloud.ListHyperVVHDSnapshotsInput{Host: snapshot.host, SnapshotPath: snapshot.path()})
+		if err != nil {
+			return volumeID{}, status.Errorf(errorCode(err), "Could not get snapshot %q: %v", snapshotID, err)
+		}
+		if len(output.Snapshots) == 0 {
+			return volumeID{}, status.Errorf(codes.NotFound, "Snapshot %q not found", snapshotID)
+		}
+		return newVolumeID(output.Snapshots[0].Host, output.Snapshots[0].Path), nil
+	case *csi.VolumeContentSource_Volume:
+		id := source.Volume.GetVolumeId()
+		if len(id) == 0 {
+			return volumeID{}, status.Error(codes.InvalidArgument, "Error retrieving volume from the volumeContentSource")
+		}
+		vol, err := parseVolumeID(id)
+		if err != nil {
+			return volumeID{}, status.Errorf(codes.NotFound, "Volume content source %q not found: %v", id, err)
+		}
+		return vol, nil
+	default:
+		return volumeID{}, status.Error(codes.InvalidArgument, "Unsupported volumeContentSource type")
+	}
+}
+
+// getTopologyHosts returns the Hyper-V hosts of the accessibility requirements, preferred
+// hosts first.
+func getTopologyHosts(requirement *csi.TopologyRequirement) []string {
+	if requirement == nil {
+		return nil
+	}
+
+	var hosts []string
+	seen := map[string]bool{}
+	for _, topology := range append(requirement.GetPreferred(), requirement.GetRequisite()...) {
+		host, ok := topology.GetSegments()[TopologyKey]
+		if ok && !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+func newCreateVolumeResponse(output *cloud.CreateHyperVVHDOutput, src *csi.VolumeContentSource, ctx map[string]string) *csi.CreateVolumeResponse {
+	// Volumes of an unnamed host are accessible from every node
+	var accessibleTopology []*csi.Topology
+	if output.Host != "" {
+		accessibleTopology = []*csi.Topology{
+			{
+				Segments: map[string]string{TopologyKey: output.Host},
+			},
+		}
 	}
 
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
-			VolumeId:      output.Path,
-			CapacityBytes: util.GiBToBytes(8), // TODO handle disk size
-			// VolumeContext: ctx, // TODO handle volume ctx
-			AccessibleTopology: []*csi.Topology{
-				{
-					Segments: segments,
-				},
-			},
-			ContentSource: src,
+			VolumeId:           newVolumeID(output.Host, output.Path).String(),
+			CapacityBytes:      int64(output.Size),
+			VolumeContext:      ctx,
+			AccessibleTopology: accessibleTopology,
+			ContentSource:      src,
 		},
 	}
 }
 
+// errorCode returns the gRPC code matching an error of the cloud package.
+func errorCode(err error) codes.Code {
+	switch {
+	case errors.Is(err, cloud.ErrNotFound):
+		return codes.NotFound
+	case errors.Is(err, cloud.ErrIdempotentParameterMismatch), errors.Is(err, cloud.ErrAlreadyExists):
+		return codes.AlreadyExists
+	case errors.Is(err, cloud.ErrInUse):
+		return codes.FailedPrecondition
+	case errors.Is(err, cloud.ErrInvalidParameter):
+		return codes.InvalidArgument
+	case errors.Is(err, cloud.ErrAccessDenied):
+		return codes.PermissionDenied
+	case errors.Is(err, cloud.ErrTimeout):
+		return codes.DeadlineExceeded
+	default:
+		return codes.Internal
+	}
+}
+
 func validateFormattingOption(volumeCapabilities []*csi.VolumeCapability, paramName string, fsConfigs map[string]fileSystemConfig) error {
 	for _, volCap := range volumeCapabilities {
 		if isBlock(volCap) {
@@ -436,7 +1172,13 @@ func validateFormattingOption(volumeCapabilities []*csi.VolumeCapability, paramN
 			return status.Error(codes.InvalidArgument, "CreateVolume: mount is nil within volume capability")
 		}
 
-		fsType := mountVolume.GetFsType()
+		fsType := strings.ToLower(mountVolume.GetFsType())
+		if len(fsType) == 0 {
+			fsType = defaultFsType
+		}
+		if _, ok := ValidFSTypes[fsType]; !ok {
+			return status.Errorf(codes.InvalidArgument, "Cannot use %s with unsupported fstype %s", paramName, fsType)
+		}
 		if supported := fsConfigs[fsType].isParameterSupported(paramName); !supported {
 			return status.Errorf(codes.InvalidArgument, "Cannot use %s with fstype %s", paramName, fsType)
 		}
@@ -471,6 +1213,18 @@ func validateDeleteVolumeRequest(req *csi.DeleteVolumeRequest) error {
 	return nil
 }
 
+func validateControllerExpandVolumeRequest(req *csi.ControllerExpandVolumeRequest) error {
+	if len(req.GetVolumeId()) == 0 {
+		return status.Error(codes.InvalidArgument, "Volume ID not provided")
+	}
+
+	if req.GetCapacityRange() == nil {
+		return status.Error(codes.InvalidArgument, "Capacity range not provided")
+	}
+
+	return nil
+}
+
 func validateControllerPublishVolumeRequest(req *csi.ControllerPublishVolumeRequest) error {
 	if len(req.GetVolumeId()) == 0 {
 		return status.Error(codes.InvalidArgument, "Volume ID not provided")
@@ -503,6 +1257,26 @@ func validateControllerUnpublishVolumeRequest(req *csi.ControllerUnpublishVolume
 	return nil
 }
 
+func validateCreateSnapshotRequest(req *csi.CreateSnapshotRequest) error {
+	if len(req.GetName()) == 0 {
+		return status.Error(codes.InvalidArgument, "Snapshot name not provided")
+	}
+
+	if len(req.GetSourceVolumeId()) == 0 {
+		return status.Error(codes.InvalidArgument, "Snapshot volume source ID not provided")
+	}
+
+	return nil
+}
+
+func validateDeleteSnapshotRequest(req *csi.DeleteSnapshotRequest) error {
+	if len(req.GetSnapshotId()) == 0 {
+		return status.Error(codes.InvalidArgument, "Snapshot ID not provided")
+	}
+
+	return nil
+}
+
 func isValidVolumeCapabilities(v []*csi.VolumeCapability) bool {
 	for _, c := range v {
 		if !isValidCapability(c) {
@@ -540,6 +1314,27 @@ func isBlock(capability *csi.VolumeCapability) bool {
 	return isBlk
 }
 
+// parseSectorSize parses a sector size parameter. Hyper-V only supports 512 and 4096 byte sectors.
+func parseSectorSize(key, value string) (uint32, error) {
+	size, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || (size != 512 && size != 4096) {
+		return 0, status.Errorf(codes.InvalidArgument, "Invalid %s %q, must be 512 or 4096", key, value)
+	}
+	return uint32(size), nil
+}
+
+// inheritSectorSizes sets the sector sizes of a clone to the ones of its source, which cannot change.
+func inheritSectorSizes(logical, physical *uint32, source *cloud.GetHyperVVHDOutput, sourcePath string) error {
+	if *logical > 0 && *logical != source.LogicalSectorSize {
+		return status.Errorf(codes.InvalidArgument, "Logical sector size %d differs from the logical sector size %d of %q", *logical, source.LogicalSectorSize, sourcePath)
+	}
+	if *physical > 0 && *physical != source.PhysicalSectorSize {
+		return status.Errorf(codes.InvalidArgument, "Physical sector size %d differs from the physical sector size %d of %q", *physical, source.PhysicalSectorSize, sourcePath)
+	}
+	*logical, *physical = source.LogicalSectorSize, source.PhysicalSectorSize
+	return nil
+}
+
 func getVolSizeBytes(req *csi.CreateVolumeRequest) (int64, error) {
 	var volSizeBytes int64
 	capRange := req.GetCapacityRange()
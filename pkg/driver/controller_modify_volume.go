@@ -17,201 +17,133 @@ limitations under the License.
 package driver
 
 import (
-	// "context"
-	// "errors"
-	// "fmt"
-	// "reflect"
-	// "strconv"
-	// "strings"
-	// "time"
-
-	// "github.com/awslabs/volume-modifier-for-k8s/pkg/rpc"
-	// "github.com/nhduc2001kt/hyperv-csi-driver/pkg/cloud"
-	// "github.com/nhduc2001kt/hyperv-csi-driver/pkg/coalescer"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/nhduc2001kt/hyperv-csi-driver/pkg/cloud"
+	"github.com/nhduc2001kt/hyperv-csi-driver/pkg/driver/internal"
+	"github.com/nhduc2001kt/hyperv-csi-driver/pkg/util"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
-	// "k8s.io/klog/v2"
+	"k8s.io/klog/v2"
 )
 
-// const (
-// 	ModificationKeyVolumeType = "type"
-// 	// Retained for backwards compatibility, but not recommended.
-// 	DeprecatedModificationKeyVolumeType = "volumeType"
+// modifyVolumeRequest holds the storage QoS parameters of a request. Parameters that are
+// not set keep their current value.
+type modifyVolumeRequest struct {
+	maximumIops *uint64
+	minimumIops *uint64
+	qosPolicyID *string
+}
 
-// 	ModificationKeyIOPS = "iops"
+func (d *ControllerService) ControllerModifyVolume(ctx context.Context, req *csi.ControllerModifyVolumeRequest) (*csi.ControllerModifyVolumeResponse, error) {
+	klog.V(4).InfoS("ControllerModifyVolume: called", "args", util.SanitizeRequest(req))
 
-// 	ModificationKeyThroughput = "throughput"
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID not provided")
+	}
 
-// 	ModificationAddTag = "tagSpecification"
+	options, err := parseModifyVolumeParameters(req.GetMutableParameters())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid mutable parameter: %v", err)
+	}
+	if options.isEmpty() {
+		return &csi.ControllerModifyVolumeResponse{}, nil
+	}
 
-// 	ModificationDeleteTag = "tagDeletion"
-// )
+	if !d.inFlight.Insert(volumeID) {
+		return nil, status.Error(codes.Aborted, fmt.Sprintf(internal.VolumeOperationAlreadyExistsErrorMsg, volumeID))
+	}
+	defer d.inFlight.Delete(volumeID)
 
-type modifyVolumeRequest struct {
-	newSize           int64
-	// modifyDiskOptions cloud.ModifyDiskOptions
-	// modifyTagsOptions cloud.ModifyTagsOptions
-}
+	vol, err := parseVolumeID(volumeID)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "Volume %q not found: %v", volumeID, err)
+	}
+
+	vhd, err := d.cloud.GetHyperVVHD(ctx, &cloud.GetHyperVVHDInput{Host: vol.host, Path: vol.path()})
+	if err != nil {
+		return nil, status.Errorf(errorCode(err), "Could not get volume %q: %v", volumeID, err)
+	}
+
+	qos := options.apply(vhd.QoS)
+	if err := validateQoS(qos); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid storage QoS for volume %q: %v", volumeID, err)
+	}
 
-// func (d *ControllerService) GetCSIDriverModificationCapability(
-// 	_ context.Context,
-// 	_ *rpc.GetCSIDriverModificationCapabilityRequest,
-// ) (*rpc.GetCSIDriverModificationCapabilityResponse, error) {
-// 	return &rpc.GetCSIDriverModificationCapabilityResponse{}, nil
-// }
-
-// func (d *ControllerService) ModifyVolumeProperties(
-// 	ctx context.Context,
-// 	req *rpc.ModifyVolumePropertiesRequest,
-// ) (*rpc.ModifyVolumePropertiesResponse, error) {
-// 	klog.V(4).InfoS("ModifyVolumeProperties called", "req", req)
-// 	name := req.GetName()
-// 	if name == "" {
-// 		return nil, status.Error(codes.InvalidArgument, "Volume name not provided")
-// 	}
-
-// 	options, err := parseModifyVolumeParameters(req.GetParameters())
-// 	if err != nil {
-// 		return nil, err
-// 	}
-
-// 	_, err = d.modifyVolumeCoalescer.Coalesce(name, *options)
-// 	if err != nil {
-// 		return nil, err
-// 	}
-
-// 	return &rpc.ModifyVolumePropertiesResponse{}, nil
-// }
-
-// func newModifyVolumeCoalescer(c cloud.Cloud, o *Options) coalescer.Coalescer[modifyVolumeRequest, int32] {
-// 	return coalescer.New[modifyVolumeRequest, int32](o.ModifyVolumeRequestHandlerTimeout, mergeModifyVolumeRequest, executeModifyVolumeRequest(c))
-// }
-
-// func mergeModifyVolumeRequest(input modifyVolumeRequest, existing modifyVolumeRequest) (modifyVolumeRequest, error) {
-// 	if input.newSize != 0 {
-// 		if existing.newSize != 0 && input.newSize != existing.newSize {
-// 			return existing, fmt.Errorf("different size was requested by a previous request. Current: %d, Requested: %d", existing.newSize, input.newSize)
-// 		}
-// 		existing.newSize = input.newSize
-// 	}
-// 	if input.modifyDiskOptions.IOPS != 0 {
-// 		if existing.modifyDiskOptions.IOPS != 0 && input.modifyDiskOptions.IOPS != existing.modifyDiskOptions.IOPS {
-// 			return existing, fmt.Errorf("different IOPS was requested by a previous request. Current: %d, Requested: %d", existing.modifyDiskOptions.IOPS, input.modifyDiskOptions.IOPS)
-// 		}
-// 		existing.modifyDiskOptions.IOPS = input.modifyDiskOptions.IOPS
-// 	}
-// 	if input.modifyDiskOptions.Throughput != 0 {
-// 		if existing.modifyDiskOptions.Throughput != 0 && input.modifyDiskOptions.Throughput != existing.modifyDiskOptions.Throughput {
-// 			return existing, fmt.Errorf("different throughput was requested by a previous request. Current: %d, Requested: %d", existing.modifyDiskOptions.Throughput, input.modifyDiskOptions.Throughput)
-// 		}
-// 		existing.modifyDiskOptions.Throughput = input.modifyDiskOptions.Throughput
-// 	}
-// 	if input.modifyDiskOptions.VolumeType != "" {
-// 		if existing.modifyDiskOptions.VolumeType != "" && input.modifyDiskOptions.VolumeType != existing.modifyDiskOptions.VolumeType {
-// 			return existing, fmt.Errorf("different volume type was requested by a previous request. Current: %s, Requested: %s", existing.modifyDiskOptions.VolumeType, input.modifyDiskOptions.VolumeType)
-// 		}
-// 		existing.modifyDiskOptions.VolumeType = input.modifyDiskOptions.VolumeType
-// 	}
-// 	if len(input.modifyTagsOptions.TagsToAdd) > 0 || len(input.modifyTagsOptions.TagsToDelete) > 0 {
-// 		if (len(existing.modifyTagsOptions.TagsToAdd) > 0 || len(existing.modifyTagsOptions.TagsToDelete) > 0) && !(reflect.DeepEqual(input.modifyTagsOptions, existing.modifyTagsOptions)) {
-// 			return existing, fmt.Errorf("different tags were requested by a previous request. Current: %v, Requested: %v", existing.modifyTagsOptions, input.modifyTagsOptions)
-// 		}
-// 		existing.modifyTagsOptions = cloud.ModifyTagsOptions{
-// 			TagsToAdd:    input.modifyTagsOptions.TagsToAdd,
-// 			TagsToDelete: input.modifyTagsOptions.TagsToDelete,
-// 		}
-// 	}
-// 	return existing, nil
-// }
-
-// func executeModifyTagsRequest(volumeID string, options modifyVolumeRequest, c cloud.Cloud, ctx context.Context) error {
-// 	if len(options.modifyTagsOptions.TagsToAdd) > 0 || len(options.modifyTagsOptions.TagsToDelete) > 0 {
-// 		err := c.ModifyTags(ctx, volumeID, options.modifyTagsOptions)
-// 		if err != nil {
-// 			if errors.Is(err, cloud.ErrInvalidArgument) {
-// 				return status.Errorf(codes.InvalidArgument, "Could not modify volume tags (invalid argument) %q: %v", volumeID, err)
-// 			}
-// 			return status.Errorf(codes.Internal, "Could not modify volume tags %q: %v", volumeID, err)
-// 		}
-// 	}
-// 	return nil
-// }
-
-// func executeModifyVolumeRequest(c cloud.Cloud) func(string, modifyVolumeRequest) (int32, error) {
-// 	return func(volumeID string, req modifyVolumeRequest) (int32, error) {
-// 		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-// 		defer cancel()
-// 		err := executeModifyTagsRequest(volumeID, req, c, ctx)
-// 		if err != nil {
-// 			return 0, err
-// 		}
-// 		if (req.modifyDiskOptions.IOPS != 0) || (req.modifyDiskOptions.Throughput != 0) || (req.modifyDiskOptions.VolumeType != "") || (req.newSize != 0) {
-// 			actualSizeGiB, err := c.ResizeOrModifyDisk(ctx, volumeID, req.newSize, &req.modifyDiskOptions)
-// 			if err != nil {
-// 				if errors.Is(err, cloud.ErrInvalidArgument) {
-// 					return 0, status.Errorf(codes.InvalidArgument, "Could not modify volume (invalid argument) %q: %v", volumeID, err)
-// 				} else if errors.Is(err, cloud.ErrNotFound) {
-// 					return 0, status.Errorf(codes.NotFound, "Could not modify volume (not found) %q: %v", volumeID, err)
-// 				}
-// 				return 0, status.Errorf(codes.Internal, "Could not modify volume %q: %v", volumeID, err)
-// 			} else {
-// 				return actualSizeGiB, nil
-// 			}
-// 		}
-// 		// No change to the volume was requested, so return an empty result with no error
-// 		return 0, nil
-// 	}
-// }
+	// The QoS is applied even if it is unchanged, a previous call may have failed to reach every VM
+	input := &cloud.ModifyHyperVVHDInput{
+		Host:        vol.host,
+		Path:        vol.path(),
+		MaximumIops: options.maximumIops,
+		MinimumIops: options.minimumIops,
+		QosPolicyID: options.qosPolicyID,
+	}
+	if _, err := d.cloud.ModifyHyperVVHD(ctx, input); err != nil {
+		return nil, status.Errorf(errorCode(err), "Could not modify volume %q: %v", volumeID, err)
+	}
+
+	return &csi.ControllerModifyVolumeResponse{}, nil
+}
 
 func parseModifyVolumeParameters(params map[string]string) (*modifyVolumeRequest, error) {
-	options := modifyVolumeRequest{
-		// modifyTagsOptions: cloud.ModifyTagsOptions{
-		// 	TagsToAdd:    make(map[string]string),
-		// 	TagsToDelete: make([]string, 0),
-		// },
+	options := &modifyVolumeRequest{}
+	for key, value := range params {
+		if err := options.setParameter(key, value); err != nil {
+			return nil, err
+		}
 	}
-	for key, _ := range params {
-		switch key {
-		// case ModificationKeyIOPS:
-		// 	iops, err := strconv.ParseInt(value, 10, 32)
-		// 	if err != nil {
-		// 		return nil, status.Errorf(codes.InvalidArgument, "Could not parse IOPS: %q", value)
-		// 	}
-		// 	options.modifyDiskOptions.IOPS = int32(iops)
-		// case ModificationKeyThroughput:
-		// 	throughput, err := strconv.ParseInt(value, 10, 32)
-		// 	if err != nil {
-		// 		return nil, status.Errorf(codes.InvalidArgument, "Could not parse throughput: %q", value)
-		// 	}
-		// 	options.modifyDiskOptions.Throughput = int32(throughput)
-		// case DeprecatedModificationKeyVolumeType:
-		// 	if _, ok := params[ModificationKeyVolumeType]; ok {
-		// 		klog.Infof("Ignoring deprecated key `volumeType` because preferred key `type` is present")
-		// 		continue
-		// 	}
-		// 	klog.InfoS("Key `volumeType` is deprecated, please use `type` instead")
-		// 	options.modifyDiskOptions.VolumeType = value
-		// case ModificationKeyVolumeType:
-		// 	options.modifyDiskOptions.VolumeType = value
-		default:
-			return nil, status.Errorf(codes.InvalidArgument, "Invalid mutable parameter key: %s", key)
-			// switch {
-			// case strings.HasPrefix(key, ModificationAddTag):
-			// 	st := strings.SplitN(value, "=", 2)
-			// 	if len(st) < 2 {
-			// 		return nil, status.Errorf(codes.InvalidArgument, "Invalid tag specification: %v", st)
-			// 	}
-			// 	options.modifyTagsOptions.TagsToAdd[st[0]] = st[1]
-			// case strings.HasPrefix(key, ModificationDeleteTag):
-			// 	options.modifyTagsOptions.TagsToDelete = append(options.modifyTagsOptions.TagsToDelete, value)
-			// default:
-			// 	return nil, status.Errorf(codes.InvalidArgument, "Invalid mutable parameter key: %s", key)
-			// }
+	return options, nil
+}
+
+// setParameter parses a storage QoS parameter, of a StorageClass or of a VolumeAttributesClass.
+func (r *modifyVolumeRequest) setParameter(key, value string) error {
+	switch strings.ToLower(key) {
+	case MaximumIopsKey:
+		iops, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("could not parse maximumIops %q: %w", value, err)
 		}
+		r.maximumIops = &iops
+	case MinimumIopsKey:
+		iops, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("could not parse minimumIops %q: %w", value, err)
+		}
+		r.minimumIops = &iops
+	case QosPolicyIDKey:
+		r.qosPolicyID = &value
+	default:
+		return fmt.Errorf("invalid mutable parameter key: %s", key)
+	}
+	return nil
+}
+
+func (r *modifyVolumeRequest) isEmpty() bool {
+	return r.maximumIops == nil && r.minimumIops == nil && r.qosPolicyID == nil
+}
+
+// apply returns qos with the parameters of the request set.
+func (r *modifyVolumeRequest) apply(qos cloud.HyperVVHDQoS) cloud.HyperVVHDQoS {
+	if r.maximumIops != nil {
+		qos.MaximumIops = *r.maximumIops
+	}
+	if r.minimumIops != nil {
+		qos.MinimumIops = *r.minimumIops
+	}
+	if r.qosPolicyID != nil {
+		qos.QosPolicyID = *r.qosPolicyID
+	}
+	return qos
+}
+
+func validateQoS(qos cloud.HyperVVHDQoS) error {
+	if qos.MaximumIops > 0 && qos.MinimumIops > qos.MaximumIops {
+		return fmt.Errorf("minimumIops %d is greater than maximumIops %d", qos.MinimumIops, qos.MaximumIops)
 	}
-	// if err := validateExtraTags(options.modifyTagsOptions.TagsToAdd, false); err != nil {
-	// 	return nil, status.Errorf(codes.InvalidArgument, "Invalid tag value: %v", err)
-	// }
-	// TODO: validateExtraTags
-	return &options, nil
+	return nil
 }
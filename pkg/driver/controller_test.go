@@ -0,0 +1,96 @@
+package driver
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestValidateFormattingOption(t *testing.T) {
+	mountCap := func(fsType string) *csi.VolumeCapability {
+		return &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{FsType: fsType},
+			},
+		}
+	}
+	blockCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{
+			Block: &csi.VolumeCapability_BlockVolume{},
+		},
+	}
+
+	testCases := []struct {
+		name         string
+		volCaps      []*csi.VolumeCapability
+		paramName    string
+		expectedCode codes.Code
+	}{
+		{
+			name:      "success: fsBlockSize with ext4",
+			volCaps:   []*csi.VolumeCapability{mountCap(FSTypeExt4)},
+			paramName: FSBlockSizeKey,
+		},
+		{
+			name:      "success: fsBlockSize with xfs",
+			volCaps:   []*csi.VolumeCapability{mountCap(FSTypeXfs)},
+			paramName: FSBlockSizeKey,
+		},
+		{
+			name:      "success: inodeSize with xfs",
+			volCaps:   []*csi.VolumeCapability{mountCap("XFS")},
+			paramName: InodeSizeKey,
+		},
+		{
+			name:      "success: bytesPerInode with ext3",
+			volCaps:   []*csi.VolumeCapability{mountCap(FSTypeExt3)},
+			paramName: BytesPerInodeKey,
+		},
+		{
+			name:      "success: ext4BigAlloc with the default fstype",
+			volCaps:   []*csi.VolumeCapability{mountCap("")},
+			paramName: Ext4BigAllocKey,
+		},
+		{
+			name:         "fail: ext4ClusterSize with ext3",
+			volCaps:      []*csi.VolumeCapability{mountCap(FSTypeExt3)},
+			paramName:    Ext4ClusterSizeKey,
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "fail: numberOfInodes with xfs",
+			volCaps:      []*csi.VolumeCapability{mountCap(FSTypeXfs)},
+			paramName:    NumberOfInodesKey,
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "fail: bytesPerInode with one xfs capability",
+			volCaps:      []*csi.VolumeCapability{mountCap(FSTypeExt4), mountCap(FSTypeXfs)},
+			paramName:    BytesPerInodeKey,
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "fail: unsupported fstype",
+			volCaps:      []*csi.VolumeCapability{mountCap(FSTypeNtfs)},
+			paramName:    FSBlockSizeKey,
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "fail: block volume",
+			volCaps:      []*csi.VolumeCapability{blockCap},
+			paramName:    InodeSizeKey,
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateFormattingOption(tc.volCaps, tc.paramName, FileSystemConfigs)
+			if code := status.Code(err); code != tc.expectedCode {
+				t.Errorf("expected code %v, got %v: %v", tc.expectedCode, code, err)
+			}
+		})
+	}
+}
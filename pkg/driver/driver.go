@@ -28,10 +28,9 @@ type Driver struct {
 func NewDriver(c cloud.Cloud, o *options.Options, m mounter.Mounter, k kubernetes.Interface) (*Driver, error) {
 	klog.InfoS("Driver Information", "Driver", DriverName, "Version", driverVersion)
 
-	// if err := ValidateDriverOptions(o); err != nil {
-	// 	return nil, fmt.Errorf("invalid driver options: %w", err)
-	// }
-	// TODO: validate options
+	if err := ValidateDriverOptions(o); err != nil {
+		return nil, fmt.Errorf("invalid driver options: %w", err)
+	}
 
 	driver := &Driver{
 		options: o,
@@ -39,11 +38,11 @@ func NewDriver(c cloud.Cloud, o *options.Options, m mounter.Mounter, k kubernete
 
 	switch o.Mode {
 	case mode.ControllerMode:
-		driver.controller = NewControllerService(c, o)
+		driver.controller = NewControllerService(c, o, k)
 	case mode.NodeMode:
 		driver.node = NewNodeService(o, m, k)
 	case mode.AllMode:
-		driver.controller = NewControllerService(c, o)
+		driver.controller = NewControllerService(c, o, k)
 		driver.node = NewNodeService(o, m, k)
 	default:
 		return nil, fmt.Errorf("unknown mode: %s", o.Mode)
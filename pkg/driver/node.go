@@ -18,10 +18,14 @@ package driver
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -56,14 +60,20 @@ const (
 
 	// MaxVolumesPerController is the number of devices per controller.
 	MaxVolumesPerController = 64
+
+	// stagedDevicesDir is the directory of the state directory that records how to find the disk
+	// of each staged volume, so that NodeUnstageVolume can delete its device after the target is
+	// unmounted.
+	stagedDevicesDir = "devices"
 )
 
 var (
 	// nodeCaps represents the capability of node service.
 	nodeCaps = []csi.NodeServiceCapability_RPC_Type{
 		csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
-		// csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
+		csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
 		csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
+		csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
 	}
 
 	// taintRemovalInitialDelay is the initial delay for node taint removal.
@@ -132,9 +142,13 @@ func (d *NodeService) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 		return nil, status.Error(codes.InvalidArgument, "Volume Attribute is not valid")
 	}
 
-	// If the access type is block, do nothing for stage
+	// If the access type is block, only record the device, so that NodeUnstageVolume removes it
+	// before the controller detaches the disk
 	if _, isAccessTypeBlock := volCap.GetAccessType().(*csi.VolumeCapability_Block); isAccessTypeBlock {
-		klog.V(4).InfoS("NodeStageVolume: called. Since it is a block device, ignoring...", "volumeID", volumeID, "target", target)
+		klog.V(4).InfoS("NodeStageVolume: called. Since it is a block device, only recording its device...", "volumeID", volumeID, "target", target)
+		if err := d.writeStagedDevice(volumeID, req.GetPublishContext()); err != nil {
+			return nil, status.Errorf(codes.Internal, "could not record the device of volume %s: %v", volumeID, err)
+		}
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
 
@@ -163,7 +177,7 @@ func (d *NodeService) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 	if err != nil {
 		return nil, err
 	}
-	vhdBlockSize, err := recheckFormattingOptionParameter(context, VHDBlockSizeKey, FileSystemConfigs, fsType)
+	fsBlockSize, err := recheckFormattingOptionParameter(context, FSBlockSizeKey, FileSystemConfigs, fsType)
 	if err != nil {
 		return nil, err
 	}
@@ -198,27 +212,12 @@ func (d *NodeService) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 		d.inFlight.Delete(volumeID)
 	}()
 
-	controllerNumberStr, ok := req.GetPublishContext()[ControllerNumberKey]
-	if !ok {
-		return nil, status.Error(codes.InvalidArgument, "Device path not provided")
-	}
-	controllerNumber, err := strconv.Atoi(controllerNumberStr)
-	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "Invalid controller number %s", controllerNumberStr)
-	}
-
-	controllerLocationStr, ok := req.GetPublishContext()[ControllerLocationKey]
-	if !ok {
-		return nil, status.Error(codes.InvalidArgument, "Device path not provided")
-	}
-	controllerLocation, err := strconv.Atoi(controllerLocationStr)
+	devicePath, err := d.findDevicePath(req.GetPublishContext())
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, "Invalid controller location %s", controllerLocationStr)
+		return nil, err
 	}
-
-	devicePath, err := d.mounter.GetSCSIBlockDevicePath(&controllerNumber, nil, nil, &controllerLocation)
-	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to get SCSI block device path: %v", err)
+	if err = d.writeStagedDevice(volumeID, req.GetPublishContext()); err != nil {
+		return nil, status.Errorf(codes.Internal, "could not record the device of volume %s: %v", volumeID, err)
 	}
 
 	partition := ""
@@ -272,11 +271,19 @@ func (d *NodeService) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 	// FormatAndMount will format only if needed
 	klog.V(4).InfoS("NodeStageVolume: staging volume", "source", source, "volumeID", volumeID, "target", target, "fstype", fsType)
 	formatOptions := []string{}
-	if len(vhdBlockSize) > 0 {
+	if len(fsBlockSize) > 0 {
+		if fsType == FSTypeXfs {
+			fsBlockSize = "size=" + fsBlockSize
+		}
+		formatOptions = append(formatOptions, "-b", fsBlockSize)
+	}
+	// Filesystem blocks and XFS sectors must not be smaller than the 4K sectors of a disk
+	if sectorSize := getFormatSectorSize(volumeContext); sectorSize >= 4096 {
 		if fsType == FSTypeXfs {
-			vhdBlockSize = "size=" + vhdBlockSize
+			formatOptions = append(formatOptions, "-s", "size="+strconv.Itoa(sectorSize))
+		} else if len(fsBlockSize) == 0 {
+			formatOptions = append(formatOptions, "-b", strconv.Itoa(sectorSize))
 		}
-		formatOptions = append(formatOptions, "-b", vhdBlockSize)
 	}
 	if len(inodeSize) > 0 {
 		option := "-I"
@@ -297,9 +304,10 @@ func (d *NodeService) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 	if len(ext4ClusterSize) > 0 {
 		formatOptions = append(formatOptions, "-C", ext4ClusterSize)
 	}
-	// if fsType == FSTypeXfs && d.options.LegacyXFSProgs {
-	// 	formatOptions = append(formatOptions, "-m", "bigtime=0,inobtcount=0,reflink=0")
-	// }
+	// Kernels before 5.10 cannot mount XFS filesystems with the features of newer xfsprogs
+	if fsType == FSTypeXfs && d.options.LegacyXFSProgs {
+		formatOptions = append(formatOptions, "-m", "bigtime=0,inobtcount=0,reflink=0")
+	}
 
 	err = d.mounter.FormatAndMountSensitiveWithFormatOptions(source, target, fsType, mountOptions, nil, formatOptions)
 	if err != nil {
@@ -357,6 +365,26 @@ func (d *NodeService) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 	// reply 0 OK.
 	if refCount == 0 {
 		klog.V(5).InfoS("[Debug] NodeUnstageVolume: target not mounted", "target", target)
+		// Block volumes are never mounted at the target, and a previous call may have unmounted
+		// the target but failed to delete the device
+		publishContext, err := d.readStagedDevice(volumeID)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "could not read the device of volume %s: %v", volumeID, err)
+		}
+		if publishContext != nil {
+			device, err := d.findStagedDevicePath(publishContext)
+			if err != nil {
+				return nil, err
+			}
+			if device != "" {
+				if err := d.deleteSCSIDevice(volumeID, device); err != nil {
+					return nil, err
+				}
+			}
+			if err := d.removeStagedDevice(volumeID); err != nil {
+				return nil, status.Errorf(codes.Internal, "could not remove the device record of volume %s: %v", volumeID, err)
+			}
+		}
 		return &csi.NodeUnstageVolumeResponse{}, nil
 	}
 
@@ -369,72 +397,91 @@ func (d *NodeService) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "Could not unmount target %q: %v", target, err)
 	}
+
+	// Remove the device before the controller detaches the disk, so that the kernel does not lose
+	// it with dirty buffers. The disk stays attached until this call succeeds, so a retry finds
+	// the same device by the record of NodeStageVolume.
+	if err := d.deleteSCSIDevice(volumeID, dev); err != nil {
+		return nil, err
+	}
+	if err := d.removeStagedDevice(volumeID); err != nil {
+		return nil, status.Errorf(codes.Internal, "could not remove the device record of volume %s: %v", volumeID, err)
+	}
 	klog.V(4).InfoS("NodeUnStageVolume: successfully unstaged volume", "volumeID", volumeID, "target", target)
 	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
-// func (d *NodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
-// 	klog.V(4).InfoS("NodeExpandVolume: called", "args", util.SanitizeRequest(req))
-// 	volumeID := req.GetVolumeId()
-// 	if len(volumeID) == 0 {
-// 		return nil, status.Error(codes.InvalidArgument, "Volume ID not provided")
-// 	}
-// 	volumePath := req.GetVolumePath()
-// 	if len(volumePath) == 0 {
-// 		return nil, status.Error(codes.InvalidArgument, "volume path must be provided")
-// 	}
-
-// 	volumeCapability := req.GetVolumeCapability()
-// 	// VolumeCapability is optional, if specified, use that as source of truth
-// 	if volumeCapability != nil {
-// 		caps := []*csi.VolumeCapability{volumeCapability}
-// 		if !isValidVolumeCapabilities(caps) {
-// 			return nil, status.Error(codes.InvalidArgument, ("VolumeCapability is invalid"))
-// 		}
-
-// 		if blk := volumeCapability.GetBlock(); blk != nil {
-// 			// Noop for Block NodeExpandVolume
-// 			klog.V(4).InfoS("NodeExpandVolume: called. Since it is a block device, ignoring...", "volumeID", volumeID, "volumePath", volumePath)
-// 			return &csi.NodeExpandVolumeResponse{}, nil
-// 		}
-// 	} else {
-// 		// TODO use util.GenericResizeFS
-// 		// VolumeCapability is nil, check if volumePath point to a block device
-// 		isBlock, err := d.mounter.IsBlockDevice(volumePath)
-// 		if err != nil {
-// 			return nil, status.Errorf(codes.Internal, "failed to determine if volumePath [%v] is a block device: %v", volumePath, err)
-// 		}
-// 		if isBlock {
-// 			// Skip resizing for Block NodeExpandVolume
-// 			bcap, err := d.mounter.GetBlockSizeBytes(volumePath)
-// 			if err != nil {
-// 				return nil, status.Errorf(codes.Internal, "failed to get block capacity on path %s: %v", req.GetVolumePath(), err)
-// 			}
-// 			klog.V(4).InfoS("NodeExpandVolume: called, since given volumePath is a block device, ignoring...", "volumeID", volumeID, "volumePath", volumePath)
-// 			return &csi.NodeExpandVolumeResponse{CapacityBytes: bcap}, nil
-// 		}
-// 	}
-// 	deviceName, _, err := d.mounter.GetDeviceNameFromMount(volumePath)
-// 	if err != nil {
-// 		return nil, status.Errorf(codes.Internal, "failed to get device name from mount %s: %v", volumePath, err)
-// 	}
-
-// 	devicePath, err := d.mounter.FindDevicePath(deviceName, volumeID, "", d.metadata.GetRegion())
-// 	if err != nil {
-// 		return nil, status.Errorf(codes.NotFound, "failed to find device path for device name %s for mount %s: %v", deviceName, req.GetVolumePath(), err)
-// 	}
-
-// 	// TODO: lock per volume ID to have some idempotency
-// 	if _, err = d.mounter.Resize(devicePath, volumePath); err != nil {
-// 		return nil, status.Errorf(codes.Internal, "Could not resize volume %q (%q): %v", volumeID, devicePath, err)
-// 	}
-
-// 	bcap, err := d.mounter.GetBlockSizeBytes(devicePath)
-// 	if err != nil {
-// 		return nil, status.Errorf(codes.Internal, "failed to get block capacity on path %s: %v", req.GetVolumePath(), err)
-// 	}
-// 	return &csi.NodeExpandVolumeResponse{CapacityBytes: bcap}, nil
-// }
+func (d *NodeService) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
+	klog.V(4).InfoS("NodeExpandVolume: called", "args", util.SanitizeRequest(req))
+	volumeID := req.GetVolumeId()
+	if len(volumeID) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID not provided")
+	}
+	volumePath := req.GetVolumePath()
+	if len(volumePath) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "volume path must be provided")
+	}
+
+	volumeCapability := req.GetVolumeCapability()
+	// VolumeCapability is optional, if specified, use that as source of truth
+	if volumeCapability != nil {
+		caps := []*csi.VolumeCapability{volumeCapability}
+		if !isValidVolumeCapabilities(caps) {
+			return nil, status.Error(codes.InvalidArgument, ("VolumeCapability is invalid"))
+		}
+
+		if blk := volumeCapability.GetBlock(); blk != nil {
+			// Noop for Block NodeExpandVolume
+			klog.V(4).InfoS("NodeExpandVolume: called. Since it is a block device, ignoring...", "volumeID", volumeID, "volumePath", volumePath)
+			return &csi.NodeExpandVolumeResponse{}, nil
+		}
+	} else {
+		// VolumeCapability is nil, check if volumePath point to a block device
+		isBlock, err := d.mounter.IsBlockDevice(volumePath)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to determine if volumePath [%v] is a block device: %v", volumePath, err)
+		}
+		if isBlock {
+			// Skip resizing for Block NodeExpandVolume
+			bcap, err := d.mounter.GetBlockSizeBytes(volumePath)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to get block capacity on path %s: %v", req.GetVolumePath(), err)
+			}
+			klog.V(4).InfoS("NodeExpandVolume: called, since given volumePath is a block device, ignoring...", "volumeID", volumeID, "volumePath", volumePath)
+			return &csi.NodeExpandVolumeResponse{CapacityBytes: bcap}, nil
+		}
+	}
+
+	if ok := d.inFlight.Insert(volumeID); !ok {
+		return nil, status.Errorf(codes.Aborted, VolumeOperationAlreadyExists, volumeID)
+	}
+	defer d.inFlight.Delete(volumeID)
+
+	deviceName, _, err := d.mounter.GetDeviceNameFromMount(volumePath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get device name from mount %s: %v", volumePath, err)
+	}
+
+	devicePath, err := d.mounter.FindDevicePath(deviceName, "")
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "failed to find device path for device name %s for mount %s: %v", deviceName, req.GetVolumePath(), err)
+	}
+
+	// The guest does not notice that the VHD grew until the SCSI device is rescanned
+	if err = d.mounter.RescanBlockDevice(devicePath); err != nil {
+		return nil, status.Errorf(codes.Internal, "Could not rescan volume %q (%q): %v", volumeID, devicePath, err)
+	}
+
+	if _, err = d.mounter.Resize(devicePath, volumePath); err != nil {
+		return nil, status.Errorf(codes.Internal, "Could not resize volume %q (%q): %v", volumeID, devicePath, err)
+	}
+
+	bcap, err := d.mounter.GetBlockSizeBytes(devicePath)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get block capacity on path %s: %v", req.GetVolumePath(), err)
+	}
+	return &csi.NodeExpandVolumeResponse{CapacityBytes: bcap}, nil
+}
 
 func (d *NodeService) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
 	klog.V(4).InfoS("NodePublishVolume: called", "args", util.SanitizeRequest(req))
@@ -520,67 +567,145 @@ func (d *NodeService) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
-// func (d *NodeService) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
-// 	klog.V(4).InfoS("NodeGetVolumeStats: called", "args", req)
-// 	if len(req.GetVolumeId()) == 0 {
-// 		return nil, status.Error(codes.InvalidArgument, "NodeGetVolumeStats volume ID was empty")
-// 	}
-// 	if len(req.GetVolumePath()) == 0 {
-// 		return nil, status.Error(codes.InvalidArgument, "NodeGetVolumeStats volume path was empty")
-// 	}
-
-// 	exists, err := d.mounter.PathExists(req.GetVolumePath())
-// 	if err != nil {
-// 		return nil, status.Errorf(codes.Internal, "unknown error when stat on %s: %v", req.GetVolumePath(), err)
-// 	}
-// 	if !exists {
-// 		return nil, status.Errorf(codes.NotFound, "path %s does not exist", req.GetVolumePath())
-// 	}
-
-// 	isBlock, err := d.mounter.IsBlockDevice(req.GetVolumePath())
-
-// 	if err != nil {
-// 		return nil, status.Errorf(codes.Internal, "failed to determine whether %s is block device: %v", req.GetVolumePath(), err)
-// 	}
-// 	if isBlock {
-// 		bcap, blockErr := d.mounter.GetBlockSizeBytes(req.GetVolumePath())
-// 		if blockErr != nil {
-// 			return nil, status.Errorf(codes.Internal, "failed to get block capacity on path %s: %v", req.GetVolumePath(), blockErr)
-// 		}
-// 		return &csi.NodeGetVolumeStatsResponse{
-// 			Usage: []*csi.VolumeUsage{
-// 				{
-// 					Unit:  csi.VolumeUsage_BYTES,
-// 					Total: bcap,
-// 				},
-// 			},
-// 		}, nil
-// 	}
-
-// 	metricsProvider := volume.NewMetricsStatFS(req.GetVolumePath())
-
-// 	metrics, err := metricsProvider.GetMetrics()
-// 	if err != nil {
-// 		return nil, status.Errorf(codes.Internal, "failed to get fs info on path %s: %v", req.GetVolumePath(), err)
-// 	}
-
-// 	return &csi.NodeGetVolumeStatsResponse{
-// 		Usage: []*csi.VolumeUsage{
-// 			{
-// 				Unit:      csi.VolumeUsage_BYTES,
-// 				Available: metrics.Available.AsDec().UnscaledBig().Int64(),
-// 				Total:     metrics.Capacity.AsDec().UnscaledBig().Int64(),
-// 				Used:      metrics.Used.AsDec().UnscaledBig().Int64(),
-// 			},
-// 			{
-// 				Unit:      csi.VolumeUsage_INODES,
-// 				Available: metrics.InodesFree.AsDec().UnscaledBig().Int64(),
-// 				Total:     metrics.Inodes.AsDec().UnscaledBig().Int64(),
-// 				Used:      metrics.InodesUsed.AsDec().UnscaledBig().Int64(),
-// 			},
-// 		},
-// 	}, nil
-// }
+func (d *NodeService) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
+	klog.V(4).InfoS("NodeGetVolumeStats: called", "args", req)
+	if len(req.GetVolumeId()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "NodeGetVolumeStats volume ID was empty")
+	}
+	if len(req.GetVolumePath()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "NodeGetVolumeStats volume path was empty")
+	}
+
+	exists, err := d.mounter.PathExists(req.GetVolumePath())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "unknown error when stat on %s: %v", req.GetVolumePath(), err)
+	}
+	if !exists {
+		return nil, status.Errorf(codes.NotFound, "path %s does not exist", req.GetVolumePath())
+	}
+
+	condition, err := d.getVolumeCondition(req.GetVolumePath(), req.GetStagingTargetPath())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get condition of volume at path %s: %v", req.GetVolumePath(), err)
+	}
+
+	usage, err := d.getVolumeUsage(req.GetVolumePath())
+	if err != nil {
+		// The usage of a device that disappeared cannot be read, its condition tells why
+		if !condition.GetAbnormal() {
+			return nil, status.Errorf(codes.Internal, "failed to get usage of volume at path %s: %v", req.GetVolumePath(), err)
+		}
+		klog.InfoS("NodeGetVolumeStats: could not get usage of abnormal volume", "volumePath", req.GetVolumePath(), "err", err)
+	}
+
+	return &csi.NodeGetVolumeStatsResponse{
+		Usage:           usage,
+		VolumeCondition: condition,
+	}, nil
+}
+
+// getVolumeUsage returns the size of a block volume, or the bytes and inodes used by the
+// filesystem of a filesystem volume.
+func (d *NodeService) getVolumeUsage(volumePath string) ([]*csi.VolumeUsage, error) {
+	isBlock, err := d.mounter.IsBlockDevice(volumePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine whether %s is block device: %w", volumePath, err)
+	}
+	if isBlock {
+		size, err := d.mounter.GetBlockSizeBytes(volumePath)
+		if err != nil {
+			return nil, err
+		}
+		return []*csi.VolumeUsage{
+			{
+				Unit:  csi.VolumeUsage_BYTES,
+				Total: size,
+			},
+		}, nil
+	}
+
+	stats, err := d.mounter.GetFilesystemStats(volumePath)
+	if err != nil {
+		return nil, err
+	}
+	return []*csi.VolumeUsage{
+		{
+			Unit:      csi.VolumeUsage_BYTES,
+			Available: stats.AvailableBytes,
+			Total:     stats.TotalBytes,
+			Used:      stats.UsedBytes,
+		},
+		{
+			Unit:      csi.VolumeUsage_INODES,
+			Available: stats.AvailableInodes,
+			Total:     stats.TotalInodes,
+			Used:      stats.UsedInodes,
+		},
+	}, nil
+}
+
+// getVolumeCondition reports the problems of a volume that kubelet cannot see: a SCSI device that
+// disappeared, a filesystem that was remounted read-only and errors counted by ext4.
+func (d *NodeService) getVolumeCondition(volumePath string, stagingTargetPath string) (*csi.VolumeCondition, error) {
+	isBlock, err := d.mounter.IsBlockDevice(volumePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine whether %s is block device: %w", volumePath, err)
+	}
+	if isBlock {
+		present, err := d.mounter.IsBlockDevicePresent(volumePath)
+		if err != nil {
+			return nil, err
+		}
+		if !present {
+			return &csi.VolumeCondition{Abnormal: true, Message: "SCSI device of the volume is missing"}, nil
+		}
+		return &csi.VolumeCondition{}, nil
+	}
+
+	// Staging mounts are always read-write, unlike the publish mounts of read-only volumes
+	mountPath := volumePath
+	if stagingTargetPath != "" {
+		mountPath = stagingTargetPath
+	}
+	mountPoints, err := d.mounter.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list mount points: %w", err)
+	}
+	var (
+		device  string
+		options []string
+	)
+	for _, mountPoint := range mountPoints {
+		// The last mount of a path hides the previous ones
+		if mountPoint.Path == mountPath {
+			device = mountPoint.Device
+			options = mountPoint.Opts
+		}
+	}
+	if device == "" {
+		return &csi.VolumeCondition{Abnormal: true, Message: fmt.Sprintf("Volume is not mounted at %s", mountPath)}, nil
+	}
+
+	present, err := d.mounter.IsBlockDevicePresent(device)
+	if err != nil {
+		return nil, err
+	}
+	if !present {
+		return &csi.VolumeCondition{Abnormal: true, Message: fmt.Sprintf("SCSI device %s of the volume is missing", device)}, nil
+	}
+	if stagingTargetPath != "" && slices.Contains(options, "ro") {
+		return &csi.VolumeCondition{Abnormal: true, Message: fmt.Sprintf("Filesystem on %s was remounted read-only", device)}, nil
+	}
+	errorCount, err := d.mounter.GetFilesystemErrorCount(device)
+	if err != nil {
+		return nil, err
+	}
+	if errorCount > 0 {
+		return &csi.VolumeCondition{Abnormal: true, Message: fmt.Sprintf("Filesystem on %s has hit %d errors, it needs to be checked", device, errorCount)}, nil
+	}
+
+	return &csi.VolumeCondition{}, nil
+}
 
 func (d *NodeService) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
 	klog.V(4).InfoS("NodeGetCapabilities: called", "args", req)
@@ -641,38 +766,163 @@ func (d *NodeService) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoReque
 		return nil, status.Errorf(codes.Internal, "failed to get volumes limit: %v", err)
 	}
 
+	var topology *csi.Topology
+	if info.HostName != "" {
+		topology = &csi.Topology{
+			Segments: map[string]string{TopologyKey: info.HostName},
+		}
+	}
+
 	return &csi.NodeGetInfoResponse{
-		NodeId:            info.VirtualMachineID,
-		MaxVolumesPerNode: maxVolumesPerNode,
-		// AccessibleTopology: topology,
+		NodeId:             info.VirtualMachineID,
+		MaxVolumesPerNode:  maxVolumesPerNode,
+		AccessibleTopology: topology,
 	}, nil
 }
 
-func (d *NodeService) nodePublishVolumeForBlock(req *csi.NodePublishVolumeRequest, mountOptions []string) error {
-	target := req.GetTargetPath()
-	volumeContext := req.GetVolumeContext()
+// deleteSCSIDevice deletes the SCSI device of an unstaged volume. Devices that are still in use fail
+// with FailedPrecondition, so that the disk is not detached before a retry deletes them.
+func (d *NodeService) deleteSCSIDevice(volumeID, device string) error {
+	err := d.mounter.DeleteSCSIDevice(device)
+	if errors.Is(err, mounter.ErrDeviceInUse) {
+		return status.Errorf(codes.FailedPrecondition, "device %s of volume %s is still in use: %v", device, volumeID, err)
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to delete device %s of volume %s: %v", device, volumeID, err)
+	}
+	return nil
+}
+
+// findStagedDevicePath returns the block device of the disk recorded by writeStagedDevice, like
+// findDevicePath but without waiting for it, or "" if the disk is gone. Its SCSI device is deleted,
+// so unlike findDevicePath it never falls back to the SCSI address, which may have been reused by
+// the disk of another volume: a disk without an identifier, or whose device reports another
+// identifier, is left to be removed by its detachment.
+func (d *NodeService) findStagedDevicePath(publishContext map[string]string) (string, error) {
+	diskIdentifier := publishContext[DiskIdentifierKey]
+	if diskIdentifier == "" {
+		klog.InfoS("findStagedDevicePath: skipping SCSI device deletion of disk without identifier", "controllerNumber", publishContext[ControllerNumberKey], "controllerLocation", publishContext[ControllerLocationKey])
+		return "", nil
+	}
+	devicePath, err := d.mounter.GetBlockDevicePathByDiskIdentifier(diskIdentifier)
+	if err != nil {
+		if errors.Is(err, mounter.ErrDiskNotFound) {
+			return "", nil
+		}
+		return "", status.Errorf(codes.Internal, "failed to find block device of disk %s: %v", diskIdentifier, err)
+	}
+	if err := d.mounter.VerifyDiskIdentifier(devicePath, diskIdentifier); err != nil {
+		klog.InfoS("findStagedDevicePath: skipping SCSI device deletion of block device that belongs to another disk", "device", devicePath, "diskIdentifier", diskIdentifier, "err", err)
+		return "", nil
+	}
+	return devicePath, nil
+}
+
+// stagedDevicePath returns the path of the device record of a volume. Volume IDs are hashed, since
+// they are not valid file names.
+func (d *NodeService) stagedDevicePath(volumeID string) string {
+	sum := sha256.Sum256([]byte(volumeID))
+	return filepath.Join(d.options.StateDir, stagedDevicesDir, hex.EncodeToString(sum[:])+".json")
+}
+
+// writeStagedDevice records the keys of the publish context of a volume that find its disk.
+func (d *NodeService) writeStagedDevice(volumeID string, publishContext map[string]string) error {
+	device := map[string]string{}
+	for _, key := range []string{DiskIdentifierKey, ControllerNumberKey, ControllerLocationKey} {
+		if value, ok := publishContext[key]; ok {
+			device[key] = value
+		}
+	}
+	data, err := json.Marshal(device)
+	if err != nil {
+		return err
+	}
+	path := d.stagedDevicePath(volumeID)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// readStagedDevice returns the publish context recorded by writeStagedDevice, or nil if there is
+// none.
+func (d *NodeService) readStagedDevice(volumeID string) (map[string]string, error) {
+	data, err := os.ReadFile(d.stagedDevicePath(volumeID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	device := map[string]string{}
+	if err := json.Unmarshal(data, &device); err != nil {
+		return nil, err
+	}
+	return device, nil
+}
 
-	controllerNumberStr, ok := req.GetPublishContext()[ControllerNumberKey]
+// removeStagedDevice removes the device record of a volume, if there is one.
+func (d *NodeService) removeStagedDevice(volumeID string) error {
+	err := os.Remove(d.stagedDevicePath(volumeID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// findDevicePath returns the block device of the disk a volume was attached as, waiting for a
+// hot-added disk to show up. The disk is found by the disk identifier of its VHD. Its SCSI address
+// is only used for volumes published without the identifier, since Hyper-V controller numbers do
+// not always match the SCSI host numbers of Linux.
+func (d *NodeService) findDevicePath(publishContext map[string]string) (string, error) {
+	diskIdentifier := publishContext[DiskIdentifierKey]
+	if diskIdentifier != "" {
+		// Hyper-V puts the controller location in the LUN, which narrows the rescan
+		var lun *int
+		if controllerLocation, err := strconv.Atoi(publishContext[ControllerLocationKey]); err == nil {
+			lun = &controllerLocation
+		}
+		devicePath, err := d.mounter.WaitForBlockDeviceByDiskIdentifier(diskIdentifier, lun)
+		if err != nil {
+			return "", status.Errorf(codes.Internal, "failed to find block device of disk %s: %v", diskIdentifier, err)
+		}
+		return devicePath, nil
+	}
+
+	controllerNumberStr, ok := publishContext[ControllerNumberKey]
 	if !ok {
-		return status.Error(codes.InvalidArgument, "Device path not provided")
+		return "", status.Error(codes.InvalidArgument, "Device path not provided")
 	}
 	controllerNumber, err := strconv.Atoi(controllerNumberStr)
 	if err != nil {
-		return status.Errorf(codes.InvalidArgument, "Invalid controller number %s", controllerNumberStr)
+		return "", status.Errorf(codes.InvalidArgument, "Invalid controller number %s", controllerNumberStr)
 	}
 
-	controllerLocationStr, ok := req.GetPublishContext()[ControllerLocationKey]
+	controllerLocationStr, ok := publishContext[ControllerLocationKey]
 	if !ok {
-		return status.Error(codes.InvalidArgument, "Device path not provided")
+		return "", status.Error(codes.InvalidArgument, "Device path not provided")
 	}
 	controllerLocation, err := strconv.Atoi(controllerLocationStr)
 	if err != nil {
-		return status.Errorf(codes.InvalidArgument, "Invalid controller location %s", controllerLocationStr)
+		return "", status.Errorf(codes.InvalidArgument, "Invalid controller location %s", controllerLocationStr)
 	}
 
-	devicePath, err := d.mounter.GetSCSIBlockDevicePath(&controllerNumber, nil, nil, &controllerLocation)
+	// A hot-added disk may not have shown up yet
+	devicePath, err := d.mounter.WaitForSCSIBlockDevice(controllerNumber, controllerLocation)
 	if err != nil {
-		return status.Errorf(codes.Internal, "failed to get SCSI block device path: %v", err)
+		return "", status.Errorf(codes.Internal, "failed to get SCSI block device path: %v", err)
+	}
+
+	return devicePath, nil
+}
+
+func (d *NodeService) nodePublishVolumeForBlock(req *csi.NodePublishVolumeRequest, mountOptions []string) error {
+	target := req.GetTargetPath()
+	volumeContext := req.GetVolumeContext()
+
+	devicePath, err := d.findDevicePath(req.GetPublishContext())
+	if err != nil {
+		return err
 	}
 
 	if isValidVolumeContext := isValidVolumeContext(volumeContext); !isValidVolumeContext {
@@ -981,7 +1231,7 @@ func recheckFormattingOptionParameter(context map[string]string, key string, fsC
 		// This check is already performed on the controller side
 		// However, because it is potentially security-sensitive, we redo it here to be safe
 		if isAlphanumeric := util.StringIsAlphanumeric(v); !isAlphanumeric {
-			return "", status.Errorf(codes.InvalidArgument, "Invalid %s (aborting!): %v", key, err)
+			return "", status.Errorf(codes.InvalidArgument, "Invalid %s %q (aborting!): the value must be alphanumeric", key, v)
 		}
 
 		// In the case that the default fstype does not support custom sizes we could
@@ -1007,5 +1257,23 @@ func isValidVolumeContext(volContext map[string]string) bool {
 			return false
 		}
 	}
+	for _, key := range []string{LogicalSectorSizeKey, PhysicalSectorSizeKey} {
+		if sectorSize, ok := volContext[key]; ok && sectorSize != "512" && sectorSize != "4096" {
+			klog.InfoS("invalid sector size", "key", key, "sectorSize", sectorSize)
+			return false
+		}
+	}
 	return true
 }
+
+// getFormatSectorSize returns the sector size a filesystem is aligned to, the larger of the
+// logical and physical sector sizes of the disk, or 0 if they are unknown.
+func getFormatSectorSize(volContext map[string]string) int {
+	size := 0
+	for _, key := range []string{LogicalSectorSizeKey, PhysicalSectorSizeKey} {
+		if sectorSize, err := strconv.Atoi(volContext[key]); err == nil && sectorSize > size {
+			size = sectorSize
+		}
+	}
+	return size
+}
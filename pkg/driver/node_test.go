@@ -0,0 +1,279 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/nhduc2001kt/hyperv-csi-driver/options"
+	"github.com/nhduc2001kt/hyperv-csi-driver/pkg/driver/internal"
+	"github.com/nhduc2001kt/hyperv-csi-driver/pkg/mounter"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRecheckFormattingOptionParameter(t *testing.T) {
+	testCases := []struct {
+		name          string
+		context       map[string]string
+		key           string
+		fsType        string
+		expectedValue string
+		expectedCode  codes.Code
+		expectedError string
+	}{
+		{
+			name:          "success: fsBlockSize with ext4",
+			context:       map[string]string{FSBlockSizeKey: "2048"},
+			key:           FSBlockSizeKey,
+			fsType:        FSTypeExt4,
+			expectedValue: "2048",
+		},
+		{
+			name:          "success: fsBlockSize with xfs",
+			context:       map[string]string{FSBlockSizeKey: "4096"},
+			key:           FSBlockSizeKey,
+			fsType:        "XFS",
+			expectedValue: "4096",
+		},
+		{
+			name:          "success: ext4ClusterSize with ext4",
+			context:       map[string]string{Ext4ClusterSizeKey: "16k"},
+			key:           Ext4ClusterSizeKey,
+			fsType:        FSTypeExt4,
+			expectedValue: "16k",
+		},
+		{
+			name:    "success: unset parameter is not checked",
+			context: map[string]string{InodeSizeKey: "512"},
+			key:     Ext4BigAllocKey,
+			fsType:  FSTypeXfs,
+		},
+		{
+			name:         "fail: ext4BigAlloc with ext3",
+			context:      map[string]string{Ext4BigAllocKey: "true"},
+			key:          Ext4BigAllocKey,
+			fsType:       FSTypeExt3,
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "fail: bytesPerInode with xfs",
+			context:      map[string]string{BytesPerInodeKey: "16384"},
+			key:          BytesPerInodeKey,
+			fsType:       FSTypeXfs,
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:          "fail: value that is not alphanumeric",
+			context:       map[string]string{FSBlockSizeKey: "4096 -O ^has_journal"},
+			key:           FSBlockSizeKey,
+			fsType:        FSTypeExt4,
+			expectedCode:  codes.InvalidArgument,
+			expectedError: `Invalid fsblocksize "4096 -O ^has_journal" (aborting!): the value must be alphanumeric`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			value, err := recheckFormattingOptionParameter(tc.context, tc.key, FileSystemConfigs, tc.fsType)
+			if code := status.Code(err); code != tc.expectedCode {
+				t.Fatalf("expected code %v, got %v: %v", tc.expectedCode, code, err)
+			}
+			if tc.expectedError != "" && status.Convert(err).Message() != tc.expectedError {
+				t.Errorf("expected error %q, got %q", tc.expectedError, status.Convert(err).Message())
+			}
+			if err == nil && value != tc.expectedValue {
+				t.Errorf("expected value %q, got %q", tc.expectedValue, value)
+			}
+		})
+	}
+}
+
+func TestStagedDevice(t *testing.T) {
+	const volumeID = "v1/host-a/0/pvc-1.vhdx"
+	stateDir := t.TempDir()
+	d := &NodeService{options: &options.Options{StateDir: stateDir}}
+
+	device, err := d.readStagedDevice(volumeID)
+	if err != nil || device != nil {
+		t.Fatalf("expected no record, got %v, %v", device, err)
+	}
+
+	publishContext := map[string]string{
+		DiskIdentifierKey:         "0C6B8A64-5E1E-4B0B-9B1F-2A4C7D5E6F70",
+		ControllerNumberKey:       "0",
+		ControllerLocationKey:     "3",
+		CacheAttributesPublishKey: "Default",
+	}
+	if err = d.writeStagedDevice(volumeID, publishContext); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path := d.stagedDevicePath(volumeID); filepath.Dir(path) != filepath.Join(stateDir, stagedDevicesDir) {
+		t.Errorf("expected the record in the state directory, got %s", path)
+	}
+	if other, err := d.readStagedDevice("v1/host-a/0/pvc-2.vhdx"); err != nil || other != nil {
+		t.Errorf("expected no record of another volume, got %v, %v", other, err)
+	}
+	device, err = d.readStagedDevice(volumeID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	delete(publishContext, CacheAttributesPublishKey)
+	if !reflect.DeepEqual(device, publishContext) {
+		t.Errorf("expected record %v, got %v", publishContext, device)
+	}
+
+	for range 2 {
+		if err = d.removeStagedDevice(volumeID); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if device, err = d.readStagedDevice(volumeID); err != nil || device != nil {
+		t.Errorf("expected no record after removal, got %v, %v", device, err)
+	}
+}
+
+// fakeMounter finds disks by their identifier and records the SCSI devices it deletes. Methods that
+// are not overridden panic when called.
+type fakeMounter struct {
+	mounter.Mounter
+	// devices are the block devices of disks, by disk identifier
+	devices map[string]string
+	// reused are the block devices that report the identifier of another disk
+	reused  map[string]bool
+	deleted []string
+}
+
+func (m *fakeMounter) GetDeviceNameFromMount(mountPath string) (string, int, error) {
+	return "", 0, nil
+}
+
+func (m *fakeMounter) GetBlockDevicePathByDiskIdentifier(diskIdentifier string) (string, error) {
+	if device, ok := m.devices[diskIdentifier]; ok {
+		return device, nil
+	}
+	return "", mounter.ErrDiskNotFound
+}
+
+func (m *fakeMounter) VerifyDiskIdentifier(devicePath, diskIdentifier string) error {
+	if m.reused[devicePath] {
+		return fmt.Errorf("device %s does not have disk identifier %s", devicePath, diskIdentifier)
+	}
+	return nil
+}
+
+func (m *fakeMounter) DeleteSCSIDevice(devicePath string) error {
+	m.deleted = append(m.deleted, devicePath)
+	return nil
+}
+
+func TestNodeUnstageVolumeBlock(t *testing.T) {
+	const (
+		volumeID       = "v1/host-a/0/pvc-1.vhdx"
+		stagingTarget  = "/var/lib/kubelet/plugins/kubernetes.io/csi/volumeDevices/staging/pvc-1"
+		diskIdentifier = "0C6B8A64-5E1E-4B0B-9B1F-2A4C7D5E6F70"
+		device         = "/dev/sdc"
+	)
+	m := &fakeMounter{devices: map[string]string{diskIdentifier: device}}
+	d := &NodeService{
+		mounter:  m,
+		inFlight: internal.NewInFlight(),
+		options:  &options.Options{StateDir: t.TempDir()},
+	}
+
+	_, err := d.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          volumeID,
+		StagingTargetPath: stagingTarget,
+		PublishContext: map[string]string{
+			DiskIdentifierKey:     diskIdentifier,
+			ControllerNumberKey:   "0",
+			ControllerLocationKey: "3",
+		},
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The staging target of a block volume is never mounted, the device is found by its record
+	for range 2 {
+		_, err = d.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{
+			VolumeId:          volumeID,
+			StagingTargetPath: stagingTarget,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if !reflect.DeepEqual(m.deleted, []string{device}) {
+		t.Errorf("expected device %s to be deleted once, got %v", device, m.deleted)
+	}
+	if record, err := d.readStagedDevice(volumeID); err != nil || record != nil {
+		t.Errorf("expected no record after unstaging, got %v, %v", record, err)
+	}
+}
+
+func TestFindStagedDevicePath(t *testing.T) {
+	const (
+		diskIdentifier = "0C6B8A64-5E1E-4B0B-9B1F-2A4C7D5E6F70"
+		device         = "/dev/sdc"
+	)
+	testCases := []struct {
+		name           string
+		publishContext map[string]string
+		reused         bool
+		expectedDevice string
+	}{
+		{
+			name: "success: device of the disk identifier",
+			publishContext: map[string]string{
+				DiskIdentifierKey:     diskIdentifier,
+				ControllerNumberKey:   "0",
+				ControllerLocationKey: "3",
+			},
+			expectedDevice: device,
+		},
+		{
+			name: "success: disk that is gone",
+			publishContext: map[string]string{
+				DiskIdentifierKey: "5A1D7C3E-9F2B-4E6A-8C0D-1B3F5A7C9E2D",
+			},
+		},
+		{
+			name: "success: disk without identifier is not found by its SCSI address",
+			publishContext: map[string]string{
+				ControllerNumberKey:   "0",
+				ControllerLocationKey: "3",
+			},
+		},
+		{
+			name: "success: device that reports another identifier is skipped",
+			publishContext: map[string]string{
+				DiskIdentifierKey: diskIdentifier,
+			},
+			reused: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &NodeService{mounter: &fakeMounter{
+				devices: map[string]string{diskIdentifier: device},
+				reused:  map[string]bool{device: tc.reused},
+			}}
+			devicePath, err := d.findStagedDevicePath(tc.publishContext)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if devicePath != tc.expectedDevice {
+				t.Errorf("expected device %q, got %q", tc.expectedDevice, devicePath)
+			}
+		})
+	}
+}
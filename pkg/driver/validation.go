@@ -0,0 +1,73 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nhduc2001kt/hyperv-csi-driver/options"
+	"github.com/nhduc2001kt/hyperv-csi-driver/pkg/util/types/mode"
+	"k8s.io/klog/v2"
+)
+
+// constants of tag limits.
+const (
+	// MaxTagKeyLength is the maximum length of a tag key.
+	MaxTagKeyLength = 128
+
+	// MaxTagValueLength is the maximum length of a tag value.
+	MaxTagValueLength = 256
+)
+
+func ValidateDriverOptions(options *options.Options) error {
+	if err := validateExtraTags(options.ExtraTags, options.WarnOnInvalidTag); err != nil {
+		return fmt.Errorf("invalid extra tags: %w", err)
+	}
+
+	if options.Mode == mode.AllMode || options.Mode == mode.ControllerMode {
+		if options.DynamicVHDOvercommitRatio < 1 {
+			return fmt.Errorf("dynamic VHD overcommit ratio must be at least 1, got %v", options.DynamicVHDOvercommitRatio)
+		}
+	}
+
+	return nil
+}
+
+// validateExtraTags checks tags set by users, either in the driver options or in StorageClass
+// parameters. Invalid tags are dropped when warnOnly is true.
+func validateExtraTags(tags map[string]string, warnOnly bool) error {
+	validate := func(k, v string) error {
+		if len(k) == 0 {
+			return errors.New("tag key cannot be empty")
+		}
+		if len(k) > MaxTagKeyLength {
+			return fmt.Errorf("tag key too long (actual: %d, limit: %d)", len(k), MaxTagKeyLength)
+		}
+		if len(v) > MaxTagValueLength {
+			return fmt.Errorf("tag value too long (actual: %d, limit: %d)", len(v), MaxTagValueLength)
+		}
+		if strings.HasPrefix(k, ResourceLifecycleTagPrefix) {
+			return fmt.Errorf("tag key prefix '%s' is reserved", ResourceLifecycleTagPrefix)
+		}
+		if strings.HasPrefix(k, DriverName+"/") {
+			return fmt.Errorf("tag key prefix '%s/' is reserved", DriverName)
+		}
+		switch k {
+		case NameTag, KubernetesClusterTag, PVCNameTag, PVCNamespaceTag, PVNameTag:
+			return fmt.Errorf("tag key '%s' is reserved", k)
+		}
+		return nil
+	}
+
+	for k, v := range tags {
+		if err := validate(k, v); err != nil {
+			if !warnOnly {
+				return err
+			}
+			klog.InfoS("Skipping invalid tag", "key", k, "value", v, "err", err)
+			delete(tags, k)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/nhduc2001kt/hyperv-csi-driver/pkg/util"
+)
+
+const (
+	// volumeIDVersion is the version of the volume IDs returned by CreateVolume
+	volumeIDVersion = "v1"
+	// volumeIDSeparator separates the escaped elements of a volume ID
+	volumeIDSeparator = "/"
+)
+
+// errInvalidVolumeID is returned for volume IDs that cannot identify a VHD
+var errInvalidVolumeID = errors.New("invalid volume ID")
+
+// volumeID identifies the VHD of a volume. Its string form is
+// v1/<host>/<storage root>/<file name>, with every element path escaped, so that it does not
+// depend on how the elements are joined to a path. Snapshots are identified the same way. Volumes
+// and snapshots created before versioned IDs were introduced are identified by the Windows path of
+// their VHD, which is parsed without a host.
+type volumeID struct {
+	// host is the name of the Hyper-V host holding the VHD, empty if hosts are not named
+	host string
+	// root is the directory of the VHD
+	root string
+	// file is the name of the VHD file, with its extension
+	file string
+	// legacy is true for IDs that are the Windows path of the VHD
+	legacy bool
+}
+
+// newVolumeID returns the ID of the VHD at path on host.
+func newVolumeID(host, path string) volumeID {
+	return volumeID{
+		host: host,
+		root: util.DirWinPath(path),
+		file: util.BaseWinPath(path),
+	}
+}
+
+// parseVolumeID parses a versioned volume ID, or the Windows path of a VHD.
+func parseVolumeID(id string) (volumeID, error) {
+	// Backslashes are escaped in versioned IDs
+	if strings.Contains(id, `\`) {
+		return parseLegacyVolumeID(id)
+	}
+
+	version, rest, _ := strings.Cut(id, volumeIDSeparator)
+	if version != volumeIDVersion {
+		return volumeID{}, fmt.Errorf("%w: unsupported version %q of %q", errInvalidVolumeID, version, id)
+	}
+
+	elems := strings.Split(rest, volumeIDSeparator)
+	if len(elems) != 3 {
+		return volumeID{}, fmt.Errorf("%w: %q does not have a host, a storage root and a file name", errInvalidVolumeID, id)
+	}
+	for i, elem := range elems {
+		unescaped, err := url.PathUnescape(elem)
+		if err != nil {
+			return volumeID{}, fmt.Errorf("%w: %q: %w", errInvalidVolumeID, id, err)
+		}
+		elems[i] = unescaped
+	}
+
+	v := volumeID{host: elems[0], root: elems[1], file: elems[2]}
+	if v.root == "" || v.file == "" || strings.Contains(v.file, `\`) {
+		return volumeID{}, fmt.Errorf("%w: %q does not have a storage root and a file name", errInvalidVolumeID, id)
+	}
+	return v, nil
+}
+
+// parseLegacyVolumeID parses the volume ID of a volume created before versioned IDs, which is the
+// absolute Windows path of its VHD, like C:\VHDs\pvc-1.vhdx or \\server\share\pvc-1.vhdx.
+func parseLegacyVolumeID(id string) (volumeID, error) {
+	isDrivePath := len(id) > 3 && id[1] == ':' && id[2] == '\\'
+	isUNCPath := strings.HasPrefix(id, `\\`)
+	if !isDrivePath && !isUNCPath {
+		return volumeID{}, fmt.Errorf("%w: %q is neither a versioned ID nor a Windows path", errInvalidVolumeID, id)
+	}
+	v := newVolumeID("", id)
+	v.legacy = true
+	if v.file == "" {
+		return volumeID{}, fmt.Errorf("%w: %q does not have a file name", errInvalidVolumeID, id)
+	}
+	return v, nil
+}
+
+// path returns the Windows path of the VHD.
+func (v volumeID) path() string {
+	return v.root + `\` + v.file
+}
+
+func (v volumeID) String() string {
+	return strings.Join([]string{
+		volumeIDVersion,
+		url.PathEscape(v.host),
+		url.PathEscape(v.root),
+		url.PathEscape(v.file),
+	}, volumeIDSeparator)
+}
@@ -0,0 +1,108 @@
+package driver
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseVolumeID(t *testing.T) {
+	testCases := []struct {
+		name         string
+		id           string
+		expectedHost string
+		expectedPath string
+		// expectedLegacy is set for IDs that are Windows paths
+		expectedLegacy bool
+		expectedErr    error
+	}{
+		{
+			name:         "success: versioned ID",
+			id:           newVolumeID("host-a", `C:\ProgramData\Microsoft\Windows\Virtual Hard Disks\pvc-1.vhdx`).String(),
+			expectedHost: "host-a",
+			expectedPath: `C:\ProgramData\Microsoft\Windows\Virtual Hard Disks\pvc-1.vhdx`,
+		},
+		{
+			name:         "success: versioned ID of an unnamed host",
+			id:           newVolumeID("", `\\server\share\pvc-1.vhds`).String(),
+			expectedPath: `\\server\share\pvc-1.vhds`,
+		},
+		{
+			name:         "success: versioned ID in a drive root",
+			id:           "v1/host-a/C%3A/pvc-1.vhdx",
+			expectedHost: "host-a",
+			expectedPath: `C:\pvc-1.vhdx`,
+		},
+		{
+			name:         "success: versioned snapshot ID",
+			id:           newVolumeID("host-b", `D:\VHDs\Snapshots\snapshot-1.vhdx`).String(),
+			expectedHost: "host-b",
+			expectedPath: `D:\VHDs\Snapshots\snapshot-1.vhdx`,
+		},
+		{
+			name:           "success: legacy path",
+			id:             `C:\VHDs\pvc-1.vhdx`,
+			expectedPath:   `C:\VHDs\pvc-1.vhdx`,
+			expectedLegacy: true,
+		},
+		{
+			name:           "success: legacy UNC path",
+			id:             `\\server\share\pvc-1.vhdx`,
+			expectedPath:   `\\server\share\pvc-1.vhdx`,
+			expectedLegacy: true,
+		},
+		{
+			name:        "fail: unsupported version",
+			id:          "v2/host-a/C%3A%5CVHDs/pvc-1.vhdx",
+			expectedErr: errInvalidVolumeID,
+		},
+		{
+			name:        "fail: missing file name",
+			id:          "v1/host-a/C%3A%5CVHDs",
+			expectedErr: errInvalidVolumeID,
+		},
+		{
+			name:        "fail: file name with a directory",
+			id:          "v1/host-a/C%3A/VHDs%5Cpvc-1.vhdx",
+			expectedErr: errInvalidVolumeID,
+		},
+		{
+			name:        "fail: invalid escape",
+			id:          "v1/host-a/C%3/pvc-1.vhdx",
+			expectedErr: errInvalidVolumeID,
+		},
+		{
+			name:        "fail: relative path",
+			id:          `VHDs\pvc-1.vhdx`,
+			expectedErr: errInvalidVolumeID,
+		},
+		{
+			name:        "fail: neither an ID nor a path",
+			id:          "fake-volume-id",
+			expectedErr: errInvalidVolumeID,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			v, err := parseVolumeID(tc.id)
+			if tc.expectedErr != nil {
+				if !errors.Is(err, tc.expectedErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if v.host != tc.expectedHost {
+				t.Errorf("expected host %q, got %q", tc.expectedHost, v.host)
+			}
+			if v.path() != tc.expectedPath {
+				t.Errorf("expected path %q, got %q", tc.expectedPath, v.path())
+			}
+			if v.legacy != tc.expectedLegacy {
+				t.Errorf("expected legacy %t, got %t", tc.expectedLegacy, v.legacy)
+			}
+		})
+	}
+}
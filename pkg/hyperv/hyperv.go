@@ -4,4 +4,5 @@ type HyperVClient interface {
 	HyperVVHDClient
 	HyperVVMHardDiskDriveClient
 	HyperVVMClient
+	HyperVVolumeClient
 }
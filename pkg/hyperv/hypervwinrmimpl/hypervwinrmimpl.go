@@ -1,6 +1,8 @@
 package hypervwinrmimpl
 
 import (
+	"strings"
+
 	"github.com/nhduc2001kt/hyperv-csi-driver/options"
 	"github.com/nhduc2001kt/hyperv-csi-driver/pkg/hyperv"
 	"github.com/nhduc2001kt/hyperv-csi-driver/pkg/util"
@@ -27,3 +29,12 @@ func NewClient(opts *options.Options) (hyperv.HyperVClient, error) {
 		winrmClient: winrmClient,
 	}, nil
 }
+
+// singleQuoteEscaper escapes the quotes PowerShell ends single-quoted strings with, which include
+// typographic single quotes.
+var singleQuoteEscaper = strings.NewReplacer("'", "''", "\u2018", "\u2018\u2018", "\u2019", "\u2019\u2019", "\u201a", "\u201a\u201a", "\u201b", "\u201b\u201b")
+
+// escape escapes a value to be embedded in a single-quoted PowerShell string of a script template.
+func escape(s string) string {
+	return singleQuoteEscaper.Replace(s)
+}
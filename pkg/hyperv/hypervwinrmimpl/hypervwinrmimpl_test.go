@@ -0,0 +1,40 @@
+package hypervwinrmimpl
+
+import "testing"
+
+func TestEscape(t *testing.T) {
+	testCases := []struct {
+		name     string
+		value    string
+		expected string
+	}{
+		{
+			name:     "path without quotes",
+			value:    `C:\VHDs\pvc-1.vhdx`,
+			expected: `C:\VHDs\pvc-1.vhdx`,
+		},
+		{
+			name:     "path with a quote",
+			value:    `C:\VHDs\o'brien.vhdx`,
+			expected: `C:\VHDs\o''brien.vhdx`,
+		},
+		{
+			name:     "path with typographic quotes",
+			value:    "C:\\VHDs\\\u2018a\u2019.vhdx",
+			expected: "C:\\VHDs\\\u2018\u2018a\u2019\u2019.vhdx",
+		},
+		{
+			name:     "injected command",
+			value:    `'; Remove-Item -Recurse C:\; '`,
+			expected: `''; Remove-Item -Recurse C:\; ''`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := escape(tc.value); actual != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
@@ -5,37 +5,49 @@ Import-Module Hyper-V
 $vm = Get-VM -Id '{{.ID}}'
 $vmHardDiskDrive = '{{.VMHardDiskDriveJson}}' | ConvertFrom-Json
 
-$vmHardDiskDriveObject = @( $vm | Get-VMHardDiskDrive | Where-Object { 
+$attached = @( $vm | Get-VMHardDiskDrive | Where-Object { 
 		$_.Path -eq $vmHardDiskDrive.Path
-	} | ForEach-Object { 
-		@{
-			ControllerType                = $_.ControllerType;
-			ControllerNumber              = $_.ControllerNumber;
-			ControllerLocation            = $_.ControllerLocation;
-			Path                          = $_.Path;
-			DiskNumber                    = if ($_.DiskNumber -eq $null) { 4294967295 } else { $_.DiskNumber };
-			ResourcePoolName              = $_.PoolName;
-			SupportPersistentReservations = $_.SupportPersistentReservations;
-			MaximumIops                   = $_.MaximumIops;
-			MinimumIops                   = $_.MinimumIops;
-			QosPolicyId                   = $_.QosPolicyId;	
-			OverrideCacheAttributes       = $_.WriteHardeningMethod;
-		} 
 	}
 )
 
-if ($vmHardDiskDriveObject) {
-	$out = ConvertTo-Json -InputObject $vmHardDiskDriveObject[0]
-	$out
-	return
+if (!$attached) {
+	# Persistent reservations let several VMs share a VHD Set or a shared VHDX
+	$NewVMHardDiskDriveArgs = @{
+		VMName                        = $vm.Name
+		ControllerType                = $vmHardDiskDrive.ControllerType
+		Path                          = $vmHardDiskDrive.Path
+		SupportPersistentReservations = [bool]$vmHardDiskDrive.SupportPersistentReservations
+		OverrideCacheAttributes       = $vmHardDiskDrive.OverrideCacheAttributes
+	}
+	Add-VMHardDiskDrive @NewVMHardDiskDriveArgs
 }
 
-$NewVMHardDiskDriveArgs = @{
-	VMName         = $vm.Name
-	ControllerType = $vmHardDiskDrive.ControllerType
-	Path           = $vmHardDiskDrive.Path
+# The QoS and cache attributes of the volume may have changed since it was attached. Only the
+# values that differ from those of the VM hard disk drive are applied, zero values included, so
+# that a removed limit is removed and hosts without Storage QoS keep working for volumes without QoS.
+$current = @( $vm | Get-VMHardDiskDrive | Where-Object { 
+		$_.Path -eq $vmHardDiskDrive.Path
+	}
+)
+$SetVMHardDiskDriveArgs = @{}
+if ($current -and [uint64]$current[0].MaximumIOPS -ne [uint64]$vmHardDiskDrive.MaximumIops) {
+	$SetVMHardDiskDriveArgs.MaximumIOPS = $vmHardDiskDrive.MaximumIops
+}
+if ($current -and [uint64]$current[0].MinimumIOPS -ne [uint64]$vmHardDiskDrive.MinimumIops) {
+	$SetVMHardDiskDriveArgs.MinimumIOPS = $vmHardDiskDrive.MinimumIops
+}
+# The empty GUID stands for no policy
+$qosPolicyID = if ($vmHardDiskDrive.QosPolicyId) { "$($vmHardDiskDrive.QosPolicyId)" } else { [Guid]::Empty.ToString() }
+$currentQoSPolicyID = if ($current -and $current[0].QoSPolicyID) { "$($current[0].QoSPolicyID)" } else { [Guid]::Empty.ToString() }
+if ($current -and $currentQoSPolicyID -ne $qosPolicyID) {
+	$SetVMHardDiskDriveArgs.QoSPolicyID = $qosPolicyID
+}
+if ($current -and [int]$current[0].WriteHardeningMethod -ne [int]$vmHardDiskDrive.OverrideCacheAttributes) {
+	$SetVMHardDiskDriveArgs.OverrideCacheAttributes = $vmHardDiskDrive.OverrideCacheAttributes
+}
+if ($SetVMHardDiskDriveArgs.Count -gt 0) {
+	$current | Set-VMHardDiskDrive @SetVMHardDiskDriveArgs
 }
-Add-VMHardDiskDrive @NewVMHardDiskDriveArgs
 
 $vmHardDiskDriveObject = @( $vm | Get-VMHardDiskDrive | Where-Object { 
 		$_.Path -eq $vmHardDiskDrive.Path
@@ -0,0 +1,89 @@
+$ErrorActionPreference = 'Stop'
+
+Import-Module Hyper-V
+
+$sourcePath = '{{.SourcePath}}'
+$path = '{{.Path}}'
+
+if (!(Test-Path -LiteralPath $path)) {
+  $pathDirectory = [System.IO.Path]::GetDirectoryName($path)
+  if (!(Test-Path -LiteralPath $pathDirectory)) {
+    New-Item -ItemType Directory -Force -Path $pathDirectory | Out-Null
+  }
+
+  # Copy into a staging file first so an interrupted copy is never taken for a complete snapshot
+  $stagingPath = Join-Path $pathDirectory ('~' + [System.IO.Path]::GetFileName($path))
+  if (Test-Path -LiteralPath $stagingPath) {
+    Remove-Item -LiteralPath $stagingPath -Force
+  }
+
+  # A copy of a VHD a running VM writes to would be inconsistent. A checkpoint of the VM redirects
+  # its writes to a differencing disk, so the VHD is copied as it was when the checkpoint was taken
+  # and the checkpoint is merged back afterwards. Production checkpoints, when the VM is configured
+  # for them, are application-consistent.
+  $checkpointName = 'csi-snapshot-' + [System.IO.Path]::GetFileNameWithoutExtension($path)
+  # A checkpoint left by an interrupted snapshot is merged before the VHD is looked up in the VMs
+  Get-VM | Get-VMSnapshot -Name $checkpointName -ErrorAction SilentlyContinue | Remove-VMSnapshot
+
+  $drives = @( Get-VM | Get-VMHardDiskDrive | Where-Object {
+      $_.Path -eq $sourcePath
+    }
+  )
+  # Get-VHD reports disks of running VMs as attached too, only a disk no VM references is mounted
+  # on the host itself, which no checkpoint can freeze
+  $sourceVhd = Get-VHD -Path $sourcePath
+  if ($sourceVhd.Attached -and !$drives) {
+    Write-Error -Message "VHD $sourcePath is mounted on the host" -Category ResourceBusy
+  }
+  $usedBy = @( $drives | Where-Object {
+      $vmState = (Get-VM -Id $_.VMId).State
+      $vmState -ne 'Off' -and $vmState -ne 'Saved'
+    }
+  )
+  $checkpoints = @()
+  try {
+    foreach ($drive in $usedBy) {
+      $vm = Get-VM -Id $drive.VMId
+      if ($vm.CheckpointType -eq 'Disabled') {
+        Write-Error -Message "VHD $sourcePath is in use by VM $($vm.Name), which has checkpoints disabled" -Category ResourceBusy
+      }
+      Checkpoint-VM -VM $vm -SnapshotName $checkpointName
+      $checkpoints += Get-VMSnapshot -VM $vm -Name $checkpointName
+
+      # Shared VHDX files are left out of checkpoints and keep being written to
+      $current = Get-VMHardDiskDrive -VM $vm -ControllerType $drive.ControllerType -ControllerNumber $drive.ControllerNumber -ControllerLocation $drive.ControllerLocation
+      if ($current.Path -eq $sourcePath) {
+        Write-Error -Message "VHD $sourcePath is in use by VM $($vm.Name) and cannot be checkpointed" -Category ResourceBusy
+      }
+    }
+
+    if ($sourceVhd.ParentPath) {
+      # Flatten differencing disks so the snapshot does not depend on the parent chain
+      Convert-VHD -Path $sourcePath -DestinationPath $stagingPath -VHDType Dynamic
+    }
+    else {
+      Copy-Item -LiteralPath $sourcePath -Destination $stagingPath -Force
+    }
+  }
+  finally {
+    # Removing a checkpoint merges the writes since it was taken into the VHD
+    foreach ($checkpoint in $checkpoints) {
+      $checkpoint | Remove-VMSnapshot
+    }
+  }
+
+  Move-Item -LiteralPath $stagingPath -Destination $path -Force
+}
+
+$item = Get-Item -LiteralPath $path
+$vhd = Get-VHD -Path $path
+$snapshotObject = @{
+  Path         = $item.FullName;
+  SourcePath   = $sourcePath;
+  Size         = $vhd.Size;
+  FileSize     = $vhd.FileSize;
+  CreationTime = [DateTimeOffset]::new($item.CreationTimeUtc).ToUnixTimeSeconds();
+}
+
+$snapshot = ConvertTo-Json -InputObject $snapshotObject
+$snapshot
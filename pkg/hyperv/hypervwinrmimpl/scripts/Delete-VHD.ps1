@@ -1,10 +1,24 @@
 $ErrorActionPreference = 'Stop'
 
 $path = '{{.Path}}'
-$targetDirectory = (Split-Path "$path" -Parent)
-$targetName = (Split-Path "$path" -Leaf)
-$targetName = $targetName.Substring(0, $targetName.LastIndexOf('.')).split('\')[-1]
+$targetDirectory = Split-Path -Path $path -Parent
+$targetName = [System.IO.Path]::GetFileNameWithoutExtension($path)
 
-Get-ChildItem -Path $targetDirectory | Where-Object { $_.BaseName.StartsWith($targetName) } | ForEach-Object {
-  Remove-Item $_.FullName -Force
-}
\ No newline at end of file
+# Checkpoints of a VHD and the data files of a VHD Set are named <name>_<GUID>.avhd[x]. Only those
+# are deleted, so that volumes whose name starts with the name of this one are kept.
+$artifactPattern = '^' + [regex]::Escape($targetName) + '_\{?[0-9A-Fa-f]{8}(-[0-9A-Fa-f]{4}){3}-[0-9A-Fa-f]{12}\}?\.avhdx?$'
+
+if (Test-Path -LiteralPath $targetDirectory) {
+  Get-ChildItem -LiteralPath $targetDirectory -File | Where-Object { $_.Name -match $artifactPattern } | ForEach-Object {
+    Remove-Item -LiteralPath $_.FullName -Force
+  }
+}
+
+# The tags are deleted after the VHD, so that an interrupted deletion never leaves a VHD without
+# tags, which would be deleted as owned by any cluster. A retry finds the VHD gone and removes the
+# tags file left over without checking ownership.
+foreach ($file in @($path, "$path.tags.json")) {
+  if (Test-Path -LiteralPath $file) {
+    Remove-Item -LiteralPath $file -Force
+  }
+}
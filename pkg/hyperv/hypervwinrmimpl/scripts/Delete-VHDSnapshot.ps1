@@ -0,0 +1,16 @@
+$ErrorActionPreference = 'Stop'
+
+$path = '{{.Path}}'
+
+if (Test-Path -LiteralPath $path) {
+  Remove-Item -LiteralPath $path -Force
+}
+if (Test-Path -LiteralPath "$path.tags.json") {
+  Remove-Item -LiteralPath "$path.tags.json" -Force
+}
+
+# Drop the per-source directory once its last snapshot is gone
+$directory = [System.IO.Path]::GetDirectoryName($path)
+if ((Test-Path -LiteralPath $directory) -and !(Get-ChildItem -LiteralPath $directory)) {
+  Remove-Item -LiteralPath $directory -Force
+}
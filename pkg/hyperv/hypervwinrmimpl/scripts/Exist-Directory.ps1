@@ -0,0 +1,6 @@
+$ErrorActionPreference = 'Stop'
+
+$directory = '{{.Directory}}'
+
+$exists = ConvertTo-Json -InputObject @{ Exists = [bool](Test-Path -LiteralPath $directory -PathType Container) }
+$exists
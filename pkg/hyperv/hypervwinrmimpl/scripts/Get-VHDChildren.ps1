@@ -0,0 +1,42 @@
+$ErrorActionPreference = 'Stop'
+
+Import-Module Hyper-V
+
+$directory = '{{.Directory}}'
+$parentPath = '{{.ParentPath}}'
+$vhdsObject = $null
+
+if (Test-Path $directory) {
+  $vhdsObject = @( Get-ChildItem -Path $directory -File | Where-Object {
+      $_.Extension -eq '.vhd' -or $_.Extension -eq '.vhdx' -or $_.Extension -eq '.avhd' -or $_.Extension -eq '.avhdx'
+    } | ForEach-Object { Get-VHD -Path $_.FullName } | Where-Object {
+      $_.ParentPath -eq $parentPath
+    } | ForEach-Object { @{
+        Path                    = $_.Path;
+        BlockSize               = $_.BlockSize;
+        LogicalSectorSize       = $_.LogicalSectorSize;
+        PhysicalSectorSize      = $_.PhysicalSectorSize;
+        ParentPath              = $_.ParentPath;
+        FileSize                = $_.FileSize;
+        Size                    = $_.Size;
+        MinimumSize             = $_.MinimumSize;
+        Attached                = $_.Attached;
+        DiskNumber              = $_.DiskNumber;
+        Number                  = $_.Number;
+        FragmentationPercentage = $_.FragmentationPercentage;
+        Alignment               = $_.Alignment;
+        DiskIdentifier          = $_.DiskIdentifier;
+        VHDType                 = $_.VHDType;
+        VHDFormat               = $_.VHDFormat;
+      }
+    }
+  )
+}
+
+if ($vhdsObject) {
+  $vhds = ConvertTo-Json -InputObject $vhdsObject
+  $vhds
+}
+else {
+  "[]"
+}
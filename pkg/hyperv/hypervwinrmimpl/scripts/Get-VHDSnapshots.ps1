@@ -0,0 +1,34 @@
+$ErrorActionPreference = 'Stop'
+
+Import-Module Hyper-V
+
+$directory = '{{.Directory}}'
+$name = '{{.Name}}'
+$snapshotsObject = $null
+
+# Snapshots are laid out as <directory>\<source name>\<snapshot name>.<extension>
+if (Test-Path $directory) {
+  $snapshotsObject = @( Get-ChildItem -Path $directory -Recurse -File | Where-Object {
+      ($_.Extension -eq '.vhd' -or $_.Extension -eq '.vhdx') -and
+      !$_.BaseName.StartsWith('~') -and
+      (!$name -or $_.BaseName -eq $name)
+    } | ForEach-Object {
+      $vhd = Get-VHD -Path $_.FullName
+      @{
+        Path         = $_.FullName;
+        SourcePath   = Join-Path $_.Directory.Parent.Parent.FullName ($_.Directory.Name + $_.Extension);
+        Size         = $vhd.Size;
+        FileSize     = $vhd.FileSize;
+        CreationTime = [DateTimeOffset]::new($_.CreationTimeUtc).ToUnixTimeSeconds();
+      }
+    }
+  )
+}
+
+if ($snapshotsObject) {
+  $snapshots = ConvertTo-Json -InputObject $snapshotsObject
+  $snapshots
+}
+else {
+  "[]"
+}
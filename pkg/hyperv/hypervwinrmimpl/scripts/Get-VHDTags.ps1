@@ -0,0 +1,11 @@
+$ErrorActionPreference = 'Stop'
+
+$path = '{{.Path}}'
+$tagsPath = "$path.tags.json"
+
+if (Test-Path $tagsPath) {
+  Get-Content -LiteralPath $tagsPath -Raw
+}
+else {
+  "{}"
+}
@@ -0,0 +1,76 @@
+$ErrorActionPreference = 'Stop'
+
+Import-Module Hyper-V
+
+$directory = '{{.Directory}}'
+$path = '{{.Path}}'
+$vhdsObject = $null
+
+if (Test-Path $directory) {
+  # Node IDs are the VM IDs reported by Hyper-V KVP, in upper case
+  $attachments = @{}
+  Get-VM | Get-VMHardDiskDrive | Where-Object { $_.Path } | ForEach-Object {
+    $key = $_.Path.ToLower()
+    if (-not $attachments.ContainsKey($key)) {
+      $attachments[$key] = @{ Path = $_.Path; VMIDs = @() }
+    }
+    $attachments[$key].VMIDs += $_.VMId.ToString().ToUpper()
+  }
+
+  $vhdsObject = @( Get-ChildItem -Path $directory -File | Where-Object {
+      ($_.Extension -eq '.vhd' -or $_.Extension -eq '.vhdx' -or $_.Extension -eq '.vhds') -and (-not $path -or $_.FullName -eq $path)
+    } | ForEach-Object { Get-VHD -Path $_.FullName } | ForEach-Object {
+      $attachment = $attachments[$_.Path.ToLower()]
+      $attachedVmIds = if ($attachment) { $attachment.VMIDs } else { @() }
+      # Test-VHD checks the whole chain of parents of a differencing VHD
+      $parentChainValid = $true
+      if ($_.ParentPath) {
+        $parentChainValid = [bool](Test-VHD -Path $_.Path -ErrorAction SilentlyContinue)
+      }
+      @{
+        Path                    = $_.Path;
+        BlockSize               = $_.BlockSize;
+        LogicalSectorSize       = $_.LogicalSectorSize;
+        PhysicalSectorSize      = $_.PhysicalSectorSize;
+        ParentPath              = $_.ParentPath;
+        FileSize                = $_.FileSize;
+        Size                    = $_.Size;
+        MinimumSize             = $_.MinimumSize;
+        Attached                = $_.Attached;
+        DiskNumber              = $_.DiskNumber;
+        Number                  = $_.Number;
+        FragmentationPercentage = $_.FragmentationPercentage;
+        Alignment               = $_.Alignment;
+        DiskIdentifier          = $_.DiskIdentifier;
+        VHDType                 = $_.VHDType;
+        VHDFormat               = $_.VHDFormat;
+        VMIDs                   = $attachedVmIds;
+        Missing                 = $false;
+        ParentChainValid        = $parentChainValid;
+      }
+    }
+  )
+
+  # VMs may still reference VHDs whose file was deleted or moved
+  $vhdsObject += @( $attachments.Values | Where-Object {
+      (Split-Path -Path $_.Path -Parent) -eq $directory.TrimEnd('\') -and
+      (-not $path -or $_.Path -eq $path) -and
+      -not (Test-Path $_.Path)
+    } | ForEach-Object {
+      @{
+        Path             = $_.Path;
+        VMIDs            = $_.VMIDs;
+        Missing          = $true;
+        ParentChainValid = $true;
+      }
+    }
+  )
+}
+
+if ($vhdsObject) {
+  $vhds = ConvertTo-Json -InputObject $vhdsObject -Depth 3
+  $vhds
+}
+else {
+  "[]"
+}
@@ -0,0 +1,21 @@
+$ErrorActionPreference = 'Stop'
+
+$path = '{{.Path}}'
+
+# Cluster shared volumes have no drive letter
+$volumeObject = Get-Volume -FilePath $path | ForEach-Object {
+  @{
+    DriveLetter     = [string]$_.DriveLetter;
+    FileSystemLabel = $_.FileSystemLabel;
+    Size            = $_.Size;
+    SizeRemaining   = $_.SizeRemaining;
+  }
+}
+
+if ($volumeObject) {
+  $volume = ConvertTo-Json -InputObject $volumeObject
+  $volume
+}
+else {
+  "{}"
+}
@@ -195,6 +195,8 @@ if ($vhd -and !(Test-Path $vhd.Path)) {
     }
 
     Remove-Item "$pathDirectory\$sourceVm" -Force -Recurse
+    # Copies keep the disk identifier of their source, which nodes identify disks by
+    Set-VHD -Path $vhd.Path -ResetDiskIdentifier -Force
     Get-VHD -path $vhd.Path
   }
   elseif ($source) {
@@ -204,12 +206,26 @@ if ($vhd -and !(Test-Path $vhd.Path)) {
       Get-FileFromUri -Url $source -FolderPath $pathDirectory
     }
     else {
+      # A copy of a VHD a running VM writes to is crash-inconsistent, if its lock lets it be read at all
+      $usedBy = @( Get-VM | Where-Object { $_.State -ne 'Off' -and $_.State -ne 'Saved' } | Get-VMHardDiskDrive | Where-Object {
+          $_.Path -eq $source
+        }
+      )
+      if ($usedBy -or (Get-VHD -Path $source -ErrorAction SilentlyContinue).Attached) {
+        Write-Error -Message "VHD $source is in use by a running VM or the host" -Category ResourceBusy
+      }
+
       Copy-Item $source "$pathDirectory\$pathFilename" -Force
     }
 
     Expand-Downloads -FolderPath $pathDirectory
 
     Pop-Location
+
+    # Copies keep the disk identifier of their source, which nodes identify disks by
+    if (Test-Path $vhd.Path) {
+      Set-VHD -Path $vhd.Path -ResetDiskIdentifier -Force
+    }
   }
   else {
     $NewVHDArgs = @{}
@@ -0,0 +1,11 @@
+$ErrorActionPreference = 'Stop'
+
+$path = '{{.Path}}'
+$tags = '{{.TagsJson}}'
+
+if (!(Test-Path $path)) {
+  Write-Error -Message "VHD $path does not exist" -Category ObjectNotFound
+}
+
+# Hyper-V has no tag store, tags are kept in a JSON file next to the VHD
+Set-Content -LiteralPath "$path.tags.json" -Value $tags -Encoding UTF8
@@ -0,0 +1,32 @@
+$ErrorActionPreference = 'Stop'
+
+Import-Module Hyper-V
+
+$vm = Get-VM -Id '{{.ID}}'
+$vmHardDiskDrive = '{{.VMHardDiskDriveJson}}' | ConvertFrom-Json
+
+$vmHardDiskDrivesObject = @( $vm | Get-VMHardDiskDrive | Where-Object {
+		$_.Path -eq $vmHardDiskDrive.Path
+	}
+)
+if (!$vmHardDiskDrivesObject) {
+	Write-Error -Message "VM hard disk drive $($vmHardDiskDrive.Path) is not attached to VM $($vm.Name)" -Category ObjectNotFound
+}
+
+# Only the values of the request are sent, zero values included, so that a limit or a policy can be
+# removed while hosts without Storage QoS keep working for volumes without QoS
+$SetVMHardDiskDriveArgs = @{}
+$properties = $vmHardDiskDrive.PSObject.Properties.Name
+if ($properties -contains 'MaximumIops') {
+	$SetVMHardDiskDriveArgs.MaximumIOPS = $vmHardDiskDrive.MaximumIops
+}
+if ($properties -contains 'MinimumIops') {
+	$SetVMHardDiskDriveArgs.MinimumIOPS = $vmHardDiskDrive.MinimumIops
+}
+if ($properties -contains 'QosPolicyId') {
+	# The empty GUID removes the policy
+	$SetVMHardDiskDriveArgs.QoSPolicyID = if ($vmHardDiskDrive.QosPolicyId) { $vmHardDiskDrive.QosPolicyId } else { [Guid]::Empty.ToString() }
+}
+if ($SetVMHardDiskDriveArgs.Count -gt 0) {
+	$vmHardDiskDrivesObject | Set-VMHardDiskDrive @SetVMHardDiskDriveArgs
+}
@@ -13,6 +13,9 @@ var (
 	//go:embed scripts/Exist-VHD.ps1
 	existVHDFile string
 
+	//go:embed scripts/Exist-Directory.ps1
+	existDirectoryFile string
+
 	//go:embed scripts/Patch-VHD.ps1
 	patchVHDFile string
 
@@ -24,20 +27,60 @@ var (
 
 	//go:embed scripts/Delete-VHD.ps1
 	deleteVHDFile string
+
+	//go:embed scripts/Get-VHDChildren.ps1
+	getVHDChildrenFile string
+
+	//go:embed scripts/Get-VHDs.ps1
+	getVHDsFile string
+
+	//go:embed scripts/Set-VHDTags.ps1
+	setVHDTagsFile string
+
+	//go:embed scripts/Get-VHDTags.ps1
+	getVHDTagsFile string
+
+	//go:embed scripts/Create-VHDSnapshot.ps1
+	createVHDSnapshotFile string
+
+	//go:embed scripts/Get-VHDSnapshots.ps1
+	getVHDSnapshotsFile string
+
+	//go:embed scripts/Delete-VHDSnapshot.ps1
+	deleteVHDSnapshotFile string
 )
 
 var (
-	existVHDTemplate  = template.Must(template.New("ExistVHD").Parse(existVHDFile))
-	patchVHDTemplate  = template.Must(template.New("PatchVHD").Parse(patchVHDFile))
-	resizeVHDTemplate = template.Must(template.New("ResizeVHD").Parse(resizeVHDFile))
-	getVHDTemplate    = template.Must(template.New("GetVHD").Parse(getVHDFile))
-	deleteVHDTemplate = template.Must(template.New("DeleteVHD").Parse(deleteVHDFile))
+	existVHDTemplate       = template.Must(template.New("ExistVHD").Parse(existVHDFile))
+	existDirectoryTemplate = template.Must(template.New("ExistDirectory").Parse(existDirectoryFile))
+	patchVHDTemplate       = template.Must(template.New("PatchVHD").Parse(patchVHDFile))
+	resizeVHDTemplate      = template.Must(template.New("ResizeVHD").Parse(resizeVHDFile))
+	getVHDTemplate         = template.Must(template.New("GetVHD").Parse(getVHDFile))
+	deleteVHDTemplate      = template.Must(template.New("DeleteVHD").Parse(deleteVHDFile))
+
+	getVHDChildrenTemplate = template.Must(template.New("GetVHDChildren").Parse(getVHDChildrenFile))
+	getVHDsTemplate        = template.Must(template.New("GetVHDs").Parse(getVHDsFile))
+
+	setVHDTagsTemplate = template.Must(template.New("SetVHDTags").Parse(setVHDTagsFile))
+	getVHDTagsTemplate = template.Must(template.New("GetVHDTags").Parse(getVHDTagsFile))
+
+	createVHDSnapshotTemplate = template.Must(template.New("CreateVHDSnapshot").Parse(createVHDSnapshotFile))
+	getVHDSnapshotsTemplate   = template.Must(template.New("GetVHDSnapshots").Parse(getVHDSnapshotsFile))
+	deleteVHDSnapshotTemplate = template.Must(template.New("DeleteVHDSnapshot").Parse(deleteVHDSnapshotFile))
 )
 
 type existsVHDArgs struct {
 	Path string
 }
 
+type existsDirectoryArgs struct {
+	Directory string
+}
+
+type existsDirectoryResult struct {
+	Exists bool
+}
+
 type createOrUpdateVHDArgs struct {
 	Source     string
 	SourceVm   string
@@ -58,9 +101,42 @@ type deleteVHDArgs struct {
 	Path string
 }
 
+type getVHDChildrenArgs struct {
+	Directory  string
+	ParentPath string
+}
+
+type getVHDsArgs struct {
+	Directory string
+	Path      string
+}
+
+type setVHDTagsArgs struct {
+	Path     string
+	TagsJson string
+}
+
+type getVHDTagsArgs struct {
+	Path string
+}
+
+type createVHDSnapshotArgs struct {
+	SourcePath string
+	Path       string
+}
+
+type getVHDSnapshotsArgs struct {
+	Directory string
+	Name      string
+}
+
+type deleteVHDSnapshotArgs struct {
+	Path string
+}
+
 func (c *hypervClientImpl) VHDExists(ctx context.Context, path string) (result hyperv.VHDExists, err error) {
 	err = c.winrmClient.RunScriptWithResult(ctx, existVHDTemplate, existsVHDArgs{
-		Path: path,
+		Path: escape(path),
 	}, &result)
 
 	return result, err
@@ -82,10 +158,10 @@ func (c *hypervClientImpl) CreateOrUpdateVHD(ctx context.Context, path string, s
 	}
 
 	err = c.winrmClient.RunFireAndForgetScript(ctx, patchVHDTemplate, createOrUpdateVHDArgs{
-		Source:     source,
-		SourceVm:   sourceVm,
+		Source:     escape(source),
+		SourceVm:   escape(sourceVm),
 		SourceDisk: sourceDisk,
-		VHDJson:    string(vhdJson),
+		VHDJson:    escape(string(vhdJson)),
 	})
 
 	return err
@@ -93,7 +169,7 @@ func (c *hypervClientImpl) CreateOrUpdateVHD(ctx context.Context, path string, s
 
 func (c *hypervClientImpl) ResizeVHD(ctx context.Context, path string, size uint64) (err error) {
 	err = c.winrmClient.RunFireAndForgetScript(ctx, resizeVHDTemplate, resizeVHDArgs{
-		Path: path,
+		Path: escape(path),
 		Size: size,
 	})
 
@@ -102,7 +178,7 @@ func (c *hypervClientImpl) ResizeVHD(ctx context.Context, path string, size uint
 
 func (c *hypervClientImpl) GetVHD(ctx context.Context, path string) (result hyperv.VHD, err error) {
 	err = c.winrmClient.RunScriptWithResult(ctx, getVHDTemplate, getVHDArgs{
-		Path: path,
+		Path: escape(path),
 	}, &result)
 
 	return result, err
@@ -110,7 +186,90 @@ func (c *hypervClientImpl) GetVHD(ctx context.Context, path string) (result hype
 
 func (c *hypervClientImpl) DeleteVHD(ctx context.Context, path string) (err error) {
 	err = c.winrmClient.RunFireAndForgetScript(ctx, deleteVHDTemplate, deleteVHDArgs{
-		Path: path,
+		Path: escape(path),
+	})
+
+	return err
+}
+
+func (c *hypervClientImpl) GetVHDChildren(ctx context.Context, directory string, parentPath string) (result []hyperv.VHD, err error) {
+	result = make([]hyperv.VHD, 0)
+
+	err = c.winrmClient.RunScriptWithResult(ctx, getVHDChildrenTemplate, getVHDChildrenArgs{
+		Directory:  escape(directory),
+		ParentPath: escape(parentPath),
+	}, &result)
+
+	return result, err
+}
+
+func (c *hypervClientImpl) GetVHDs(ctx context.Context, directory string, path string) (result []hyperv.AttachedVHD, err error) {
+	result = make([]hyperv.AttachedVHD, 0)
+
+	err = c.winrmClient.RunScriptWithResult(ctx, getVHDsTemplate, getVHDsArgs{
+		Directory: escape(directory),
+		Path:      escape(path),
+	}, &result)
+
+	return result, err
+}
+
+func (c *hypervClientImpl) DirectoryExists(ctx context.Context, directory string) (exists bool, err error) {
+	var result existsDirectoryResult
+	err = c.winrmClient.RunScriptWithResult(ctx, existDirectoryTemplate, existsDirectoryArgs{
+		Directory: escape(directory),
+	}, &result)
+
+	return result.Exists, err
+}
+
+func (c *hypervClientImpl) SetVHDTags(ctx context.Context, path string, tags map[string]string) (err error) {
+	tagsJson, err := json.Marshal(tags)
+	if err != nil {
+		return err
+	}
+
+	err = c.winrmClient.RunFireAndForgetScript(ctx, setVHDTagsTemplate, setVHDTagsArgs{
+		Path:     escape(path),
+		TagsJson: escape(string(tagsJson)),
+	})
+
+	return err
+}
+
+func (c *hypervClientImpl) GetVHDTags(ctx context.Context, path string) (result map[string]string, err error) {
+	result = make(map[string]string)
+
+	err = c.winrmClient.RunScriptWithResult(ctx, getVHDTagsTemplate, getVHDTagsArgs{
+		Path: escape(path),
+	}, &result)
+
+	return result, err
+}
+
+func (c *hypervClientImpl) CreateVHDSnapshot(ctx context.Context, sourcePath string, path string) (result hyperv.VHDSnapshot, err error) {
+	err = c.winrmClient.RunScriptWithResult(ctx, createVHDSnapshotTemplate, createVHDSnapshotArgs{
+		SourcePath: escape(sourcePath),
+		Path:       escape(path),
+	}, &result)
+
+	return result, err
+}
+
+func (c *hypervClientImpl) GetVHDSnapshots(ctx context.Context, directory string, name string) (result []hyperv.VHDSnapshot, err error) {
+	result = make([]hyperv.VHDSnapshot, 0)
+
+	err = c.winrmClient.RunScriptWithResult(ctx, getVHDSnapshotsTemplate, getVHDSnapshotsArgs{
+		Directory: escape(directory),
+		Name:      escape(name),
+	}, &result)
+
+	return result, err
+}
+
+func (c *hypervClientImpl) DeleteVHDSnapshot(ctx context.Context, path string) (err error) {
+	err = c.winrmClient.RunFireAndForgetScript(ctx, deleteVHDSnapshotTemplate, deleteVHDSnapshotArgs{
+		Path: escape(path),
 	})
 
 	return err
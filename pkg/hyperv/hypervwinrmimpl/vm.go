@@ -23,7 +23,7 @@ type getVMByIDArgs struct {
 
 func (c *hypervClientImpl) GetVMByID(ctx context.Context, id string) (result hyperv.VM, err error) {
 	err = c.winrmClient.RunScriptWithResult(ctx, getVmTemplate, getVMByIDArgs{
-		ID: id,
+		ID: escape(id),
 	}, &result)
 
 	return result, err
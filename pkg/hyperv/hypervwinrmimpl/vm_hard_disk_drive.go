@@ -14,6 +14,8 @@ var (
 	attachVMHardDiskDriveFile string
 	//go:embed scripts/Detach-VMHardDiskDrive.ps1
 	detachVMHardDiskDriveFile string
+	//go:embed scripts/Set-VMHardDiskDriveQoS.ps1
+	setVMHardDiskDriveQoSFile string
 	//go:embed scripts/Create-VMHardDiskDrive.ps1
 	createVMHardDiskDriveFile string
 	//go:embed scripts/Get-VMHardDiskDrives.ps1
@@ -29,6 +31,7 @@ var (
 var (
 	attachVMHardDiskDriveTemplate   = template.Must(template.New("AttachVMHardDiskDrive").Parse(attachVMHardDiskDriveFile))
 	detachVMHardDiskDriveTemplate   = template.Must(template.New("DetachVMHardDiskDrive").Parse(detachVMHardDiskDriveFile))
+	setVMHardDiskDriveQoSTemplate   = template.Must(template.New("SetVMHardDiskDriveQoS").Parse(setVMHardDiskDriveQoSFile))
 	createVMHardDiskDriveTemplate   = template.Must(template.New("CreateVMHardDiskDrive").Parse(createVMHardDiskDriveFile))
 	getVMHardDiskDrivesTemplate     = template.Must(template.New("GetVMHardDiskDrives").Parse(getVMHardDiskDrivesFile))
 	getVMHardDiskDrivesByIDTemplate = template.Must(template.New("GetVMHardDiskDrivesByID").Parse(getVMHardDiskDrivesByIDFile))
@@ -46,6 +49,20 @@ type detachVMHardDiskDriveArgs struct {
 	VMHardDiskDriveJson string
 }
 
+type setVMHardDiskDriveQoSArgs struct {
+	ID                  string
+	VMHardDiskDriveJson string
+}
+
+// vmHardDiskDriveQoS holds the storage QoS values to set. Set-VMHardDiskDriveQoS.ps1 leaves the
+// values that are omitted unchanged.
+type vmHardDiskDriveQoS struct {
+	Path        string
+	MaximumIops *uint64 `json:",omitempty"`
+	MinimumIops *uint64 `json:",omitempty"`
+	QosPolicyId *string `json:",omitempty"`
+}
+
 type createVMHardDiskDriveArgs struct {
 	VMHardDiskDriveJson string
 }
@@ -76,18 +93,28 @@ func (c *hypervClientImpl) AttachVMHardDiskDrive(
 	vmID string,
 	controllerType hyperv.ControllerType,
 	path string,
+	supportPersistentReservations bool,
+	maximumIops uint64,
+	minimumIops uint64,
+	qosPolicyId string,
+	overrideCacheAttributes hyperv.CacheAttributes,
 ) (result hyperv.VMHardDiskDrive, err error) {
 	vmHardDiskDriveJson, err := json.Marshal(hyperv.VMHardDiskDrive{
-		ControllerType: controllerType,
-		Path:           path,
+		ControllerType:                controllerType,
+		Path:                          path,
+		SupportPersistentReservations: supportPersistentReservations,
+		MaximumIops:                   maximumIops,
+		MinimumIops:                   minimumIops,
+		QosPolicyId:                   qosPolicyId,
+		OverrideCacheAttributes:       overrideCacheAttributes,
 	})
 	if err != nil {
 		return
 	}
 
 	err = c.winrmClient.RunScriptWithResult(ctx, attachVMHardDiskDriveTemplate, attachVMHardDiskDriveArgs{
-		ID:                  vmID,
-		VMHardDiskDriveJson: string(vmHardDiskDriveJson),
+		ID:                  escape(vmID),
+		VMHardDiskDriveJson: escape(string(vmHardDiskDriveJson)),
 	}, &result)
 
 	return
@@ -102,8 +129,34 @@ func (c *hypervClientImpl) DetachVMHardDiskDrive(ctx context.Context, vmID strin
 	}
 
 	err = c.winrmClient.RunFireAndForgetScript(ctx, detachVMHardDiskDriveTemplate, detachVMHardDiskDriveArgs{
-		ID:                  vmID,
-		VMHardDiskDriveJson: string(vmHardDiskDriveJson),
+		ID:                  escape(vmID),
+		VMHardDiskDriveJson: escape(string(vmHardDiskDriveJson)),
+	})
+
+	return
+}
+
+func (c *hypervClientImpl) SetVMHardDiskDriveQoS(
+	ctx context.Context,
+	vmID string,
+	path string,
+	maximumIops *uint64,
+	minimumIops *uint64,
+	qosPolicyId *string,
+) (err error) {
+	vmHardDiskDriveJson, err := json.Marshal(vmHardDiskDriveQoS{
+		Path:        path,
+		MaximumIops: maximumIops,
+		MinimumIops: minimumIops,
+		QosPolicyId: qosPolicyId,
+	})
+	if err != nil {
+		return
+	}
+
+	err = c.winrmClient.RunFireAndForgetScript(ctx, setVMHardDiskDriveQoSTemplate, setVMHardDiskDriveQoSArgs{
+		ID:                  escape(vmID),
+		VMHardDiskDriveJson: escape(string(vmHardDiskDriveJson)),
 	})
 
 	return
@@ -145,7 +198,7 @@ func (c *hypervClientImpl) CreateVMHardDiskDrive(
 	}
 
 	err = c.winrmClient.RunFireAndForgetScript(ctx, createVMHardDiskDriveTemplate, createVMHardDiskDriveArgs{
-		VMHardDiskDriveJson: string(vmHardDiskDriveJson),
+		VMHardDiskDriveJson: escape(string(vmHardDiskDriveJson)),
 	})
 
 	return err
@@ -155,7 +208,7 @@ func (c *hypervClientImpl) GetVMHardDiskDrives(ctx context.Context, vmName strin
 	result = make([]hyperv.VMHardDiskDrive, 0)
 
 	err = c.winrmClient.RunScriptWithResult(ctx, getVMHardDiskDrivesTemplate, getVMHardDiskDrivesArgs{
-		VMName: vmName,
+		VMName: escape(vmName),
 	}, &result)
 
 	return result, err
@@ -165,7 +218,7 @@ func (c *hypervClientImpl) GetVMHardDiskDrivesByID(ctx context.Context, vmID str
 	result = make([]hyperv.VMHardDiskDrive, 0)
 
 	err = c.winrmClient.RunScriptWithResult(ctx, getVMHardDiskDrivesByIDTemplate, getVMHardDiskDrivesByIDArgs{
-		ID: vmID,
+		ID: escape(vmID),
 	}, &result)
 
 	return result, err
@@ -208,10 +261,10 @@ func (c *hypervClientImpl) UpdateVMHardDiskDrive(
 	}
 
 	err = c.winrmClient.RunFireAndForgetScript(ctx, updateVMHardDiskDriveTemplate, updateVMHardDiskDriveArgs{
-		VMName:              vmName,
+		VMName:              escape(vmName),
 		ControllerNumber:    controllerNumber,
 		ControllerLocation:  controllerLocation,
-		VMHardDiskDriveJson: string(vmHardDiskDriveJson),
+		VMHardDiskDriveJson: escape(string(vmHardDiskDriveJson)),
 	})
 
 	return err
@@ -219,7 +272,7 @@ func (c *hypervClientImpl) UpdateVMHardDiskDrive(
 
 func (c *hypervClientImpl) DeleteVMHardDiskDrive(ctx context.Context, vmname string, controllerNumber int32, controllerLocation int32) (err error) {
 	err = c.winrmClient.RunFireAndForgetScript(ctx, deleteVMHardDiskDriveTemplate, deleteVMHardDiskDriveArgs{
-		VMName:             vmname,
+		VMName:             escape(vmname),
 		ControllerNumber:   controllerNumber,
 		ControllerLocation: controllerLocation,
 	})
@@ -0,0 +1,30 @@
+package hypervwinrmimpl
+
+import (
+	"context"
+	_ "embed"
+	"text/template"
+
+	"github.com/nhduc2001kt/hyperv-csi-driver/pkg/hyperv"
+)
+
+var (
+	//go:embed scripts/Get-Volume.ps1
+	getVolumeFile string
+)
+
+var (
+	getVolumeTemplate = template.Must(template.New("GetVolume").Parse(getVolumeFile))
+)
+
+type getVolumeArgs struct {
+	Path string
+}
+
+func (c *hypervClientImpl) GetVolume(ctx context.Context, path string) (result hyperv.Volume, err error) {
+	err = c.winrmClient.RunScriptWithResult(ctx, getVolumeTemplate, getVolumeArgs{
+		Path: escape(path),
+	}, &result)
+
+	return result, err
+}
@@ -186,10 +186,37 @@ type VHD struct {
 	VHDFormat               VHDFormat
 }
 
+// AttachedVHD is a VHD together with the IDs of the virtual machines it is attached to.
+type AttachedVHD struct {
+	VHD
+	VMIDs []string
+	// Missing is true if virtual machines reference the VHD but its file does not exist
+	Missing bool
+	// ParentChainValid is false if a parent of a differencing VHD is missing or was modified
+	ParentChainValid bool
+}
+
+// VHDSnapshot is a point-in-time copy of a VHD kept under a snapshot directory.
+type VHDSnapshot struct {
+	Path         string
+	SourcePath   string
+	Size         uint64
+	FileSize     uint64
+	CreationTime int64 // seconds since the Unix epoch
+}
+
 type HyperVVHDClient interface {
 	VHDExists(ctx context.Context, path string) (result VHDExists, err error)
 	CreateOrUpdateVHD(ctx context.Context, path string, source string, sourceVm string, sourceDisk int, vhdType VHDType, parentPath string, size uint64, blockSize uint32, logicalSectorSize uint32, physicalSectorSize uint32) (err error)
 	ResizeVHD(ctx context.Context, path string, size uint64) (err error)
 	GetVHD(ctx context.Context, path string) (result VHD, err error)
 	DeleteVHD(ctx context.Context, path string) (err error)
+	GetVHDChildren(ctx context.Context, directory string, parentPath string) (result []VHD, err error)
+	GetVHDs(ctx context.Context, directory string, path string) (result []AttachedVHD, err error)
+	DirectoryExists(ctx context.Context, directory string) (exists bool, err error)
+	SetVHDTags(ctx context.Context, path string, tags map[string]string) (err error)
+	GetVHDTags(ctx context.Context, path string) (result map[string]string, err error)
+	CreateVHDSnapshot(ctx context.Context, sourcePath string, path string) (result VHDSnapshot, err error)
+	GetVHDSnapshots(ctx context.Context, directory string, name string) (result []VHDSnapshot, err error)
+	DeleteVHDSnapshot(ctx context.Context, path string) (err error)
 }
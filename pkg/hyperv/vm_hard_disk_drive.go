@@ -105,6 +105,20 @@ func (x CacheAttributes) String() string {
 	return CacheAttributesName[x]
 }
 
+var (
+	ErrInvalidCacheAttributes = fmt.Errorf("the provided CacheAttributes string is not a valid value")
+)
+
+// StringToCacheAttributes parses the name of a cache attributes value, unlike ToCacheAttributes
+// it rejects unknown names.
+func StringToCacheAttributes(x string) (CacheAttributes, error) {
+	if value, exist := CacheAttributesValue[strings.ToLower(x)]; exist {
+		return value, nil
+	}
+
+	return CacheAttributes(-1), ErrInvalidCacheAttributes
+}
+
 func ToCacheAttributes(x string) CacheAttributes {
 	if integerValue, err := strconv.Atoi(x); err == nil {
 		return CacheAttributes(integerValue)
@@ -243,12 +257,26 @@ type HyperVVMHardDiskDriveClient interface {
 		vmID string,
 		controllerType ControllerType,
 		path string,
+		supportPersistentReservations bool,
+		maximumIops uint64,
+		minimumIops uint64,
+		qosPolicyId string,
+		overrideCacheAttributes CacheAttributes,
 	) (VMHardDiskDrive, error)
 	DetachVMHardDiskDrive(
 		ctx context.Context,
 		vmID string,
 		path string,
 	) error
+	// SetVMHardDiskDriveQoS sets the storage QoS values that are not nil, including zero values.
+	SetVMHardDiskDriveQoS(
+		ctx context.Context,
+		vmID string,
+		path string,
+		maximumIops *uint64,
+		minimumIops *uint64,
+		qosPolicyId *string,
+	) error
 	CreateVMHardDiskDrive(
 		ctx context.Context,
 		vmName string,
@@ -0,0 +1,17 @@
+package hyperv
+
+import (
+	"context"
+)
+
+// Volume is the Windows volume that holds a path of the Hyper-V host.
+type Volume struct {
+	DriveLetter     string
+	FileSystemLabel string
+	Size            uint64
+	SizeRemaining   uint64
+}
+
+type HyperVVolumeClient interface {
+	GetVolume(ctx context.Context, path string) (result Volume, err error)
+}
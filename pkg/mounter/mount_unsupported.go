@@ -50,10 +50,50 @@ func (m *NodeMounter) GetSCSIBlockDevicePath(host, bus, target, lun *int) (strin
 	return "", errors.New(stubMessage)
 }
 
+func (m *NodeMounter) GetBlockDevicePathByDiskIdentifier(diskIdentifier string) (string, error) {
+	return stubMessage, errors.New(stubMessage)
+}
+
+func (m *NodeMounter) RescanSCSIHosts(lun *int) error {
+	return errors.New(stubMessage)
+}
+
+func (m *NodeMounter) WaitForSCSIBlockDevice(host int, lun int) (string, error) {
+	return "", errors.New(stubMessage)
+}
+
+func (m *NodeMounter) WaitForBlockDeviceByDiskIdentifier(diskIdentifier string, lun *int) (string, error) {
+	return "", errors.New(stubMessage)
+}
+
+func (m *NodeMounter) DeleteSCSIDevice(devicePath string) error {
+	return errors.New(stubMessage)
+}
+
+func (m *NodeMounter) VerifyDiskIdentifier(devicePath, diskIdentifier string) error {
+	return errors.New(stubMessage)
+}
+
+func (m *NodeMounter) RescanBlockDevice(devicePath string) error {
+	return errors.New(stubMessage)
+}
+
 func (m *NodeMounter) GetBlockSizeBytes(devicePath string) (int64, error) {
 	return 1, errors.New(stubMessage)
 }
 
+func (m *NodeMounter) IsBlockDevicePresent(path string) (bool, error) {
+	return false, errors.New(stubMessage)
+}
+
+func (m *NodeMounter) GetFilesystemErrorCount(devicePath string) (int64, error) {
+	return 0, errors.New(stubMessage)
+}
+
+func (m *NodeMounter) GetFilesystemStats(path string) (*FilesystemStats, error) {
+	return nil, errors.New(stubMessage)
+}
+
 func (m NodeMounter) GetDeviceNameFromMount(mountPath string) (string, int, error) {
 	return stubMessage, 0, errors.New(stubMessage)
 }
@@ -1,9 +1,17 @@
 package mounter
 
 import (
+	"errors"
+
 	mountutils "k8s.io/mount-utils"
 )
 
+// ErrDiskNotFound is returned when no single block device has the identifier of a disk.
+var ErrDiskNotFound = errors.New("disk not found")
+
+// ErrDeviceInUse is returned when a block device that is still mounted or held would be removed.
+var ErrDeviceInUse = errors.New("device in use")
+
 // NodeMounter implements Mounter.
 // A superstruct of SafeFormatAndMount.
 type Mounter interface {
@@ -15,6 +23,17 @@ type Mounter interface {
 	CountSCSIHosts() (int, error)
 	CountSCSIDevices() (int, error)
 	GetSCSIBlockDevicePath(host *int, bus *int, target *int, lun *int) (string, error)
+	RescanSCSIHosts(lun *int) error
+	WaitForSCSIBlockDevice(host int, lun int) (string, error)
+	WaitForBlockDeviceByDiskIdentifier(diskIdentifier string, lun *int) (string, error)
+	DeleteSCSIDevice(devicePath string) error
+	GetBlockDevicePathByDiskIdentifier(diskIdentifier string) (string, error)
+	VerifyDiskIdentifier(devicePath, diskIdentifier string) error
+	RescanBlockDevice(devicePath string) error
+	GetBlockSizeBytes(devicePath string) (int64, error)
+	IsBlockDevicePresent(path string) (bool, error)
+	GetFilesystemErrorCount(devicePath string) (int64, error)
+	GetFilesystemStats(path string) (*FilesystemStats, error)
 	GetDeviceNameFromMount(mountPath string) (string, int, error)
 	FindDevicePath(devicePath, partition string) (string, error)
 	PathExists(path string) (bool, error)
@@ -27,10 +46,25 @@ type Mounter interface {
 	PreparePublishTarget(target string) error
 }
 
+// FilesystemStats is the usage of the filesystem mounted at a path.
+type FilesystemStats struct {
+	AvailableBytes  int64
+	TotalBytes      int64
+	UsedBytes       int64
+	AvailableInodes int64
+	TotalInodes     int64
+	UsedInodes      int64
+}
+
 // NodeMounter implements Mounter.
 // A superstruct of SafeFormatAndMount.
 type NodeMounter struct {
 	*mountutils.SafeFormatAndMount
+
+	// sysfsRoot and devRoot are prepended to the sysfs and device paths the mounter reads,
+	// so that tests can run against fake trees. Both are empty on nodes.
+	sysfsRoot string
+	devRoot   string
 }
 
 // NewNodeMounter returns a new intsance of NodeMounter.
@@ -47,5 +81,5 @@ func NewNodeMounter(hostprocess bool) (Mounter, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &NodeMounter{safeMounter}, nil
+	return &NodeMounter{SafeFormatAndMount: safeMounter}, nil
 }
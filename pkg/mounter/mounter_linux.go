@@ -4,15 +4,19 @@
 package mounter
 
 import (
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/nhduc2001kt/hyperv-csi-driver/pkg/util"
 	"golang.org/x/sys/unix"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
 	mountutils "k8s.io/mount-utils"
 	utilexec "k8s.io/utils/exec"
@@ -23,6 +27,22 @@ const (
 	diskPartitionSuffix     = ""
 )
 
+// scsiDeviceBackoff bounds the wait for hot-added disks to show up, to about 16 seconds.
+var scsiDeviceBackoff = wait.Backoff{
+	Duration: 200 * time.Millisecond,
+	Factor:   2,
+	Steps:    8,
+	Cap:      5 * time.Second,
+}
+
+// diskByIDLinkBackoff bounds the wait for udev to link a block device that showed up, to under a
+// second, since the device is usable without its link.
+var diskByIDLinkBackoff = wait.Backoff{
+	Duration: 100 * time.Millisecond,
+	Factor:   2,
+	Steps:    4,
+}
+
 // constants of paths
 const (
 	// classBlockPath represents the path to block devices.
@@ -34,8 +54,51 @@ const (
 	// classSCSIBlockDevicePath represents the path to SCSI block devices.
 	classSCSIBlockDevicePath = "device/block"
 
+	// classBlockPath represents the path to block devices.
+	classBlockPath = "/sys/class/block"
+
+	// scsiHostScanPath represents the path, relative to a SCSI host, that triggers a scan for devices.
+	scsiHostScanPath = "scan"
+
+	// scsiHostProcNamePath represents the path, relative to a SCSI host, of the name of its driver.
+	scsiHostProcNamePath = "proc_name"
+
+	// storvscDriverName is the name of the driver of Hyper-V synthetic SCSI controllers.
+	storvscDriverName = "storvsc"
+
+	// scsiDeviceDeletePath represents the path, relative to a block device, that removes its SCSI device.
+	scsiDeviceDeletePath = "device/delete"
+
+	// blockDevicePartitionPath represents the path, relative to a block device, that only exists for partitions.
+	blockDevicePartitionPath = "partition"
+
+	// blockDeviceHoldersPath represents the path, relative to a block device, of the devices that hold it.
+	blockDeviceHoldersPath = "holders"
+
+	// blockDeviceRescanPath represents the path, relative to a block device, that triggers a rescan.
+	blockDeviceRescanPath = "device/rescan"
+
+	// devBlockPath represents the path to block devices by device number.
+	devBlockPath = "/sys/dev/block"
+
+	// ext4FsPath represents the path to the state of ext4 filesystems.
+	ext4FsPath = "/sys/fs/ext4"
+
+	// ext4ErrorsCountPath represents the path, relative to an ext4 filesystem, of its error counter.
+	ext4ErrorsCountPath = "errors_count"
+
 	// devicePath represents the path to block devices.
 	devicePath = "/dev"
+
+	// diskByIDPath represents the path to the links udev names block devices by their identifiers.
+	diskByIDPath = "/dev/disk/by-id"
+
+	// scsiVPDPage83Path represents the path, relative to a block device, of its device
+	// identification VPD page.
+	scsiVPDPage83Path = "device/vpd_pg83"
+
+	// scsiWWIDPath represents the path, relative to a block device, of its world wide identifier.
+	scsiWWIDPath = "device/wwid"
 )
 
 func NewSafeMounter() (*mountutils.SafeFormatAndMount, error) {
@@ -115,7 +178,7 @@ func (m *NodeMounter) IsCorruptedMnt(err error) bool {
 // CountSCSIHosts returns the number of SCSI hosts.
 func (m *NodeMounter) CountSCSIHosts() (int, error) {
 	// Read the SCSI host directory
-	scsiHosts, err := os.ReadDir(classSCSIHostPath)
+	scsiHosts, err := os.ReadDir(filepath.Join(m.sysfsRoot, classSCSIHostPath))
 	if err != nil {
 		return 0, err
 	}
@@ -127,7 +190,7 @@ func (m *NodeMounter) CountSCSIHosts() (int, error) {
 // CountSCSIDevices returns the number of SCSI devices.
 func (m *NodeMounter) CountSCSIDevices() (int, error) {
 	// Read the SCSI device directory
-	scsiDevices, err := os.ReadDir(classSCSIDevicePath)
+	scsiDevices, err := os.ReadDir(filepath.Join(m.sysfsRoot, classSCSIDevicePath))
 	if err != nil {
 		return 0, err
 	}
@@ -138,6 +201,29 @@ func (m *NodeMounter) CountSCSIDevices() (int, error) {
 
 // GetSCSIBlockDevicePath returns the block device path for the given SCSI device.
 func (m *NodeMounter) GetSCSIBlockDevicePath(host *int, bus *int, target *int, lun *int) (string, error) {
+	names, err := m.scsiBlockDeviceNames(host, bus, target, lun)
+	if err != nil {
+		return "", err
+	}
+
+	for _, name := range names {
+		devName := filepath.Join(m.devRoot, devicePath, name)
+		ok, err := m.IsBlockDevice(devName)
+		if ok && err == nil {
+			klog.V(4).Infof("found block device %s", devName)
+			return devName, nil
+		}
+
+		klog.V(4).Infof("skipping non-block device %s", devName)
+	}
+
+	// Define the pattern to match
+	return "", errors.New("no block device found for SCSI device")
+}
+
+// scsiBlockDeviceNames returns the names of the block devices sysfs lists for the SCSI devices
+// matching the given address. Nil elements of the address match any value.
+func (m *NodeMounter) scsiBlockDeviceNames(host *int, bus *int, target *int, lun *int) ([]string, error) {
 	hostStr := util.ItoaOrDefault(host, `\d+`)
 	busStr := util.ItoaOrDefault(bus, `\d+`)
 	targetStr := util.ItoaOrDefault(target, `\d+`)
@@ -145,16 +231,18 @@ func (m *NodeMounter) GetSCSIBlockDevicePath(host *int, bus *int, target *int, l
 	pattern := fmt.Sprintf("^%s:%s:%s:%s$", hostStr, busStr, targetStr, lunStr)
 	regex, err := regexp.Compile(pattern)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	// Read the SCSI device directory
-	scsiDevices, err := os.ReadDir(classSCSIDevicePath)
+	scsiDevicePath := filepath.Join(m.sysfsRoot, classSCSIDevicePath)
+	scsiDevices, err := os.ReadDir(scsiDevicePath)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	klog.V(4).Infof("found %d SCSI devices on host", len(scsiDevices))
 
+	var names []string
 	// Iterate through each SCSI device
 	for _, device := range scsiDevices {
 		klog.V(4).Infof("checking SCSI device %s", device.Name())
@@ -166,7 +254,7 @@ func (m *NodeMounter) GetSCSIBlockDevicePath(host *int, bus *int, target *int, l
 		}
 
 		// Construct path to block devices
-		blockPath := filepath.Join(classSCSIDevicePath, dName, classSCSIBlockDevicePath)
+		blockPath := filepath.Join(scsiDevicePath, dName, classSCSIBlockDevicePath)
 
 		// Read the block device directory
 		blockDevices, err := os.ReadDir(blockPath)
@@ -176,23 +264,366 @@ func (m *NodeMounter) GetSCSIBlockDevicePath(host *int, bus *int, target *int, l
 		}
 		klog.V(4).Infof("found %d device entries on SCSI device %s", len(blockDevices), dName)
 
-		// Print found block devices
 		for _, blockDevice := range blockDevices {
-			klog.V(4).Infof("checking block device %s", blockDevice.Name())
+			names = append(names, blockDevice.Name())
+		}
+	}
 
-			devName := filepath.Join(devicePath, blockDevice.Name())
-			ok, err := m.IsBlockDevice(devName)
-			if ok && err == nil {
-				klog.V(4).Infof("found block device %s", devName)
-				return devName, nil
-			}
+	return names, nil
+}
 
-			klog.V(4).Infof("skipping non-block device %s", devName)
+// RescanSCSIHosts asks the SCSI hosts of Hyper-V SCSI controllers to scan for the device at the
+// given LUN, or for all devices if lun is nil, so that disks hot-added by Hyper-V show up without
+// waiting for the hosts to notice them. Hyper-V controller numbers do not always match the SCSI host
+// numbers of Linux, so every storvsc host is rescanned, or every host if none is known to be one.
+func (m *NodeMounter) RescanSCSIHosts(lun *int) error {
+	hostsPath := filepath.Join(m.sysfsRoot, classSCSIHostPath)
+	entries, err := os.ReadDir(hostsPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", hostsPath, err)
+	}
+	var hosts, storvscHosts []string
+	for _, entry := range entries {
+		hosts = append(hosts, entry.Name())
+		procName, err := os.ReadFile(filepath.Join(hostsPath, entry.Name(), scsiHostProcNamePath))
+		if err == nil && strings.TrimSpace(string(procName)) == storvscDriverName {
+			storvscHosts = append(storvscHosts, entry.Name())
 		}
 	}
+	if len(storvscHosts) > 0 {
+		hosts = storvscHosts
+	}
 
-	// Define the pattern to match
-	return "", errors.New("no block device found for SCSI device")
+	// Channels and targets are wildcards, Hyper-V puts the controller location in the LUN
+	scan := "- - " + util.ItoaOrDefault(lun, "-")
+	var errs []error
+	for _, host := range hosts {
+		scanPath := filepath.Join(hostsPath, host, scsiHostScanPath)
+		klog.V(4).Infof("rescanning SCSI host %s through %s with %q", host, scanPath, scan)
+		if err := os.WriteFile(scanPath, []byte(scan), 0200); err != nil {
+			errs = append(errs, fmt.Errorf("failed to rescan SCSI host %s: %w", host, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// WaitForSCSIBlockDevice rescans the SCSI hosts and waits, with scsiDeviceBackoff, until the block
+// device at the given address has a device node. Where udev runs, it then waits, with
+// diskByIDLinkBackoff, for a link in /dev/disk/by-id. A device whose link does not show up in time
+// is returned anyway.
+func (m *NodeMounter) WaitForSCSIBlockDevice(host int, lun int) (string, error) {
+	if err := m.RescanSCSIHosts(&lun); err != nil {
+		// The device may show up on its own
+		klog.InfoS("WaitForSCSIBlockDevice: failed to rescan SCSI hosts", "lun", lun, "err", err)
+	}
+
+	var device string
+	err := wait.ExponentialBackoff(scsiDeviceBackoff, func() (bool, error) {
+		names, err := m.scsiBlockDeviceNames(&host, nil, nil, &lun)
+		if err != nil {
+			klog.V(4).InfoS("WaitForSCSIBlockDevice: failed to list SCSI devices", "err", err)
+			return false, nil
+		}
+		if len(names) != 1 {
+			klog.V(4).InfoS("WaitForSCSIBlockDevice: waiting for a single block device", "host", host, "lun", lun, "devices", names)
+			return false, nil
+		}
+
+		devName := filepath.Join(m.devRoot, devicePath, names[0])
+		if _, err := os.Stat(devName); err != nil {
+			klog.V(4).InfoS("WaitForSCSIBlockDevice: waiting for device node", "device", devName, "err", err)
+			return false, nil
+		}
+		device = devName
+		return true, nil
+	})
+	if device == "" {
+		return "", fmt.Errorf("no block device found for SCSI device %d:*:*:%d: %w", host, lun, err)
+	}
+
+	m.waitForDiskByIDLink(device)
+	return device, nil
+}
+
+// WaitForBlockDeviceByDiskIdentifier rescans the SCSI hosts, for the device at the given LUN if it
+// is not nil, and waits like WaitForSCSIBlockDevice until a single block device with the given VHD
+// disk identifier has a device node. ErrDiskNotFound is returned if none shows up in time.
+func (m *NodeMounter) WaitForBlockDeviceByDiskIdentifier(diskIdentifier string, lun *int) (string, error) {
+	if err := m.RescanSCSIHosts(lun); err != nil {
+		// The device may show up on its own
+		klog.InfoS("WaitForBlockDeviceByDiskIdentifier: failed to rescan SCSI hosts", "lun", util.ItoaOrDefault(lun, "-"), "err", err)
+	}
+
+	var (
+		device  string
+		lastErr error
+	)
+	err := wait.ExponentialBackoff(scsiDeviceBackoff, func() (bool, error) {
+		devName, err := m.GetBlockDevicePathByDiskIdentifier(diskIdentifier)
+		if errors.Is(err, ErrDiskNotFound) {
+			klog.V(4).InfoS("WaitForBlockDeviceByDiskIdentifier: waiting for a single block device", "diskIdentifier", diskIdentifier, "err", err)
+			lastErr = err
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		if _, err := os.Stat(devName); err != nil {
+			klog.V(4).InfoS("WaitForBlockDeviceByDiskIdentifier: waiting for device node", "device", devName, "err", err)
+			lastErr = fmt.Errorf("%w: device node %s: %w", ErrDiskNotFound, devName, err)
+			return false, nil
+		}
+		device = devName
+		return true, nil
+	})
+	if device == "" {
+		if lastErr != nil && wait.Interrupted(err) {
+			return "", lastErr
+		}
+		return "", err
+	}
+
+	m.waitForDiskByIDLink(device)
+	return device, nil
+}
+
+// waitForDiskByIDLink waits, with diskByIDLinkBackoff, for a link in /dev/disk/by-id to the given
+// device, on nodes where udev runs.
+func (m *NodeMounter) waitForDiskByIDLink(device string) {
+	// Nodes without udev have no links to wait for
+	if _, err := os.Stat(filepath.Join(m.devRoot, diskByIDPath)); err != nil {
+		klog.V(4).InfoS("waitForDiskByIDLink: not waiting for a link in "+diskByIDPath, "device", device, "err", err)
+		return
+	}
+	err := wait.ExponentialBackoff(diskByIDLinkBackoff, func() (bool, error) {
+		return m.hasDiskByIDLink(device), nil
+	})
+	if err != nil {
+		klog.InfoS("waitForDiskByIDLink: block device has no link in "+diskByIDPath, "device", device)
+	}
+}
+
+// hasDiskByIDLink returns true if a link in /dev/disk/by-id resolves to the given device.
+func (m *NodeMounter) hasDiskByIDLink(device string) bool {
+	byIDPath := filepath.Join(m.devRoot, diskByIDPath)
+	entries, err := os.ReadDir(byIDPath)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		target, err := filepath.EvalSymlinks(filepath.Join(byIDPath, entry.Name()))
+		if err == nil && target == device {
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteSCSIDevice flushes the buffers of the disk of the given block device, or of the disk of
+// the given partition, and removes the disk from the SCSI layer, so that it is gone before Hyper-V
+// detaches it. Disks that are mounted or held, e.g. by device mapper, are not removed and
+// ErrDeviceInUse is returned. Disks that are already gone, and devices that are not SCSI disks,
+// are ignored.
+func (m *NodeMounter) DeleteSCSIDevice(device string) error {
+	canonicalDevicePath, err := filepath.EvalSymlinks(device)
+	if errors.Is(err, os.ErrNotExist) {
+		klog.V(4).InfoS("DeleteSCSIDevice: device is already gone", "device", device)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to evaluate symlink %q: %w", device, err)
+	}
+
+	name, err := m.diskName(filepath.Base(canonicalDevicePath))
+	if errors.Is(err, os.ErrNotExist) {
+		klog.V(4).InfoS("DeleteSCSIDevice: device is already gone", "device", canonicalDevicePath)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	deletePath := filepath.Join(m.sysfsRoot, classBlockPath, name, scsiDeviceDeletePath)
+	if _, err := os.Stat(deletePath); err != nil {
+		klog.V(4).InfoS("DeleteSCSIDevice: not a SCSI disk, ignoring", "device", name, "err", err)
+		return nil
+	}
+
+	if err := m.checkDiskNotInUse(name); err != nil {
+		return err
+	}
+
+	diskPath := filepath.Join(m.devRoot, devicePath, name)
+	klog.V(4).Infof("flushing buffers of block device %s", diskPath)
+	if output, err := m.Exec.Command("blockdev", "--flushbufs", diskPath).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to flush buffers of block device %q: %w, output: %s", diskPath, err, string(output))
+	}
+
+	klog.V(4).Infof("deleting SCSI device %s through %s", name, deletePath)
+	if err := os.WriteFile(deletePath, []byte("1"), 0200); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to delete SCSI device %q: %w", name, err)
+	}
+
+	return nil
+}
+
+// diskName returns the name of the disk of a block device, which is the device itself unless it is
+// a partition.
+func (m *NodeMounter) diskName(name string) (string, error) {
+	blockPath := filepath.Join(m.sysfsRoot, classBlockPath, name)
+	if _, err := os.Stat(filepath.Join(blockPath, blockDevicePartitionPath)); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		// Not a partition, if the device exists at all
+		_, err = os.Stat(blockPath)
+		return name, err
+	}
+
+	// Partitions are below their disk in sysfs
+	target, err := filepath.EvalSymlinks(blockPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to evaluate symlink %q: %w", blockPath, err)
+	}
+	return filepath.Base(filepath.Dir(target)), nil
+}
+
+// checkDiskNotInUse returns ErrDeviceInUse if the disk or one of its partitions is mounted or has
+// holders.
+func (m *NodeMounter) checkDiskNotInUse(name string) error {
+	names := map[string]struct{}{name: {}}
+	entries, err := os.ReadDir(filepath.Join(m.sysfsRoot, classBlockPath, name))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if _, err := os.Stat(filepath.Join(m.sysfsRoot, classBlockPath, name, entry.Name(), blockDevicePartitionPath)); err == nil {
+			names[entry.Name()] = struct{}{}
+		}
+	}
+
+	for n := range names {
+		holders, err := os.ReadDir(filepath.Join(m.sysfsRoot, classBlockPath, n, blockDeviceHoldersPath))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if len(holders) > 0 {
+			return fmt.Errorf("%w: block device %s is held by %s", ErrDeviceInUse, n, holders[0].Name())
+		}
+	}
+
+	mountPoints, err := m.List()
+	if err != nil {
+		return fmt.Errorf("failed to list mounts: %w", err)
+	}
+	for _, mountPoint := range mountPoints {
+		device := mountPoint.Device
+		if canonical, err := filepath.EvalSymlinks(device); err == nil {
+			device = canonical
+		}
+		if !strings.HasPrefix(device, filepath.Join(m.devRoot, devicePath)+"/") {
+			continue
+		}
+		if _, ok := names[filepath.Base(device)]; ok {
+			return fmt.Errorf("%w: block device %s is mounted at %s", ErrDeviceInUse, device, mountPoint.Path)
+		}
+	}
+
+	return nil
+}
+
+// RescanBlockDevice asks the SCSI layer to re-read the capacity of the given device.
+func (m *NodeMounter) RescanBlockDevice(devicePath string) error {
+	canonicalDevicePath, err := filepath.EvalSymlinks(devicePath)
+	if err != nil {
+		return fmt.Errorf("failed to evaluate symlink %q: %w", devicePath, err)
+	}
+
+	rescanPath := filepath.Join(m.sysfsRoot, classBlockPath, filepath.Base(canonicalDevicePath), blockDeviceRescanPath)
+	klog.V(4).Infof("rescanning block device %s through %s", canonicalDevicePath, rescanPath)
+	if err := os.WriteFile(rescanPath, []byte("1"), 0200); err != nil {
+		return fmt.Errorf("failed to rescan block device %q: %w", canonicalDevicePath, err)
+	}
+
+	return nil
+}
+
+// GetBlockSizeBytes returns the size, in bytes, of the given block device.
+func (m *NodeMounter) GetBlockSizeBytes(devicePath string) (int64, error) {
+	output, err := m.Exec.Command("blockdev", "--getsize64", devicePath).Output()
+	if err != nil {
+		return -1, fmt.Errorf("error when getting size of block volume at path %s: output: %s, err: %w", devicePath, string(output), err)
+	}
+	strOut := strings.TrimSpace(string(output))
+	gotSizeBytes, err := strconv.ParseInt(strOut, 10, 64)
+	if err != nil {
+		return -1, fmt.Errorf("failed to parse size %s as int", strOut)
+	}
+	return gotSizeBytes, nil
+}
+
+// IsBlockDevicePresent returns false if the block device at path, or the device it was bind
+// mounted from, was removed from the system.
+func (m *NodeMounter) IsBlockDevicePresent(path string) (bool, error) {
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		if errors.Is(err, unix.ENOENT) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to stat %q: %w", path, err)
+	}
+	if (st.Mode & unix.S_IFMT) != unix.S_IFBLK {
+		return false, fmt.Errorf("%q is not a block device", path)
+	}
+
+	// A bind mount keeps the device node after the device is gone, sysfs does not
+	rdev := uint64(st.Rdev)
+	return m.PathExists(filepath.Join(m.sysfsRoot, devBlockPath, fmt.Sprintf("%d:%d", unix.Major(rdev), unix.Minor(rdev))))
+}
+
+// GetFilesystemErrorCount returns the number of errors the ext4 filesystem on the given device
+// has hit. It returns 0 for other filesystems.
+func (m *NodeMounter) GetFilesystemErrorCount(devicePath string) (int64, error) {
+	canonicalDevicePath, err := filepath.EvalSymlinks(devicePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to evaluate symlink %q: %w", devicePath, err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(m.sysfsRoot, ext4FsPath, filepath.Base(canonicalDevicePath), ext4ErrorsCountPath))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to read error count of %q: %w", canonicalDevicePath, err)
+	}
+	count, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse error count %q of %q: %w", data, canonicalDevicePath, err)
+	}
+	return count, nil
+}
+
+// GetFilesystemStats returns the usage of the filesystem mounted at path.
+func (m *NodeMounter) GetFilesystemStats(path string) (*FilesystemStats, error) {
+	var statfs unix.Statfs_t
+	if err := unix.Statfs(path, &statfs); err != nil {
+		return nil, fmt.Errorf("failed to statfs %q: %w", path, err)
+	}
+
+	// Blocks reserved for root are neither available nor used
+	return &FilesystemStats{
+		AvailableBytes:  int64(statfs.Bavail) * int64(statfs.Bsize),
+		TotalBytes:      int64(statfs.Blocks) * int64(statfs.Bsize),
+		UsedBytes:       int64(statfs.Blocks-statfs.Bfree) * int64(statfs.Bsize),
+		AvailableInodes: int64(statfs.Ffree),
+		TotalInodes:     int64(statfs.Files),
+		UsedInodes:      int64(statfs.Files - statfs.Ffree),
+	}, nil
 }
 
 // This function is mirrored in ./sanity_test.go to make sure sanity test covered this block of code
@@ -280,34 +711,128 @@ func (m *NodeMounter) appendPartition(devicePath, partition string) string {
 	return devicePath + diskPartitionSuffix + partition
 }
 
-// // execRunner is a helper to inject exec.Comamnd().CombinedOutput() for verifyVolumeSerialMatch
-// // Tests use a mocked version that does not actually execute any binaries.
-// func execRunner(name string, arg ...string) ([]byte, error) {
-// 	return exec.Command(name, arg...).CombinedOutput()
-// }
-
-// // verifyVolumeSerialMatch checks the volume serial of the device against the expected volume.
-// func verifyVolumeSerialMatch(canonicalDevicePath string, strippedVolumeName string, execRunner func(string, ...string) ([]byte, error)) error {
-// 	// In some rare cases, a race condition can lead to the /dev/disk/by-id/ symlink becoming out of date
-// 	// See https://github.com/kubernetes-sigs/aws-ebs-csi-driver/issues/1224 for more info
-// 	// Attempt to use lsblk to double check that the nvme device selected was the correct volume
-// 	output, err := execRunner("lsblk", "--noheadings", "--ascii", "--nodeps", "--output", "SERIAL", canonicalDevicePath)
-
-// 	if err == nil {
-// 		// Look for an EBS volume ID in the output, compare all matches against what we expect
-// 		// (in some rare cases there may be multiple matches due to lsblk printing partitions)
-// 		// If no volume ID is in the output (non-Nitro instances, SBE devices, etc) silently proceed
-// 		volumeRegex := regexp.MustCompile(`vol[a-z0-9]+`)
-// 		for _, volume := range volumeRegex.FindAllString(string(output), -1) {
-// 			klog.V(6).InfoS("Comparing volume serial", "canonicalDevicePath", canonicalDevicePath, "expected", strippedVolumeName, "actual", volume)
-// 			if volume != strippedVolumeName {
-// 				return fmt.Errorf("refusing to mount %s because it claims to be %s but should be %s", canonicalDevicePath, volume, strippedVolumeName)
-// 			}
-// 		}
-// 	} else {
-// 		// If the command fails (for example, because lsblk is not available), silently ignore the error and proceed
-// 		klog.V(5).ErrorS(err, "Ignoring lsblk failure", "canonicalDevicePath", canonicalDevicePath, "strippedVolumeName", strippedVolumeName)
-// 	}
-
-// 	return nil
-// }
+// GetBlockDevicePathByDiskIdentifier returns the block device whose SCSI identifiers contain the
+// given VHD disk identifier. It returns ErrDiskNotFound if no device, or more than one, matches.
+func (m *NodeMounter) GetBlockDevicePathByDiskIdentifier(diskIdentifier string) (string, error) {
+	forms, err := diskIdentifierForms(diskIdentifier)
+	if err != nil {
+		return "", err
+	}
+
+	devices := map[string]struct{}{}
+
+	// udev names disks after their SCSI identifiers
+	byIDPath := filepath.Join(m.devRoot, diskByIDPath)
+	entries, err := os.ReadDir(byIDPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("failed to read %s: %w", byIDPath, err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), "-part") || !matchesDiskIdentifier([]byte(entry.Name()), forms) {
+			continue
+		}
+		device, err := filepath.EvalSymlinks(filepath.Join(byIDPath, entry.Name()))
+		if err != nil {
+			klog.V(4).InfoS("failed to evaluate symlink", "path", entry.Name(), "err", err)
+			continue
+		}
+		devices[device] = struct{}{}
+	}
+
+	// sysfs has the identifiers even where udev does not run
+	blockPath := filepath.Join(m.sysfsRoot, classBlockPath)
+	blockDevices, err := os.ReadDir(blockPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", blockPath, err)
+	}
+	for _, blockDevice := range blockDevices {
+		for _, identifier := range m.readSCSIIdentifiers(blockDevice.Name()) {
+			if matchesDiskIdentifier(identifier, forms) {
+				devices[filepath.Join(m.devRoot, devicePath, blockDevice.Name())] = struct{}{}
+				break
+			}
+		}
+	}
+
+	switch len(devices) {
+	case 0:
+		return "", fmt.Errorf("%w: no block device has disk identifier %s", ErrDiskNotFound, diskIdentifier)
+	case 1:
+		for device := range devices {
+			klog.V(4).InfoS("found block device by disk identifier", "diskIdentifier", diskIdentifier, "device", device)
+			return device, nil
+		}
+	}
+	// Copies of a VHD made by older versions share its disk identifier
+	return "", fmt.Errorf("%w: %d block devices have disk identifier %s", ErrDiskNotFound, len(devices), diskIdentifier)
+}
+
+// VerifyDiskIdentifier returns an error if the SCSI identifiers of the block device do not contain
+// the given VHD disk identifier.
+func (m *NodeMounter) VerifyDiskIdentifier(devicePath, diskIdentifier string) error {
+	canonicalDevicePath, err := filepath.EvalSymlinks(devicePath)
+	if err != nil {
+		return fmt.Errorf("failed to evaluate symlink %q: %w", devicePath, err)
+	}
+	return verifyVolumeSerialMatch(canonicalDevicePath, diskIdentifier, m.readSCSIIdentifiers)
+}
+
+// readSCSIIdentifiers returns the device identification VPD page and the WWID of a block device,
+// as far as the kernel exposes them. Partitions have none.
+func (m *NodeMounter) readSCSIIdentifiers(name string) [][]byte {
+	var identifiers [][]byte
+	for _, file := range []string{scsiVPDPage83Path, scsiWWIDPath} {
+		data, err := os.ReadFile(filepath.Join(m.sysfsRoot, classBlockPath, name, file))
+		if err == nil && len(data) > 0 {
+			identifiers = append(identifiers, data)
+		}
+	}
+	return identifiers
+}
+
+// verifyVolumeSerialMatch checks the SCSI identifiers of the device against the disk identifier of
+// the expected VHD, so that a disk found by its SCSI address is never taken for another volume.
+func verifyVolumeSerialMatch(canonicalDevicePath string, diskIdentifier string, readIdentifiers func(string) [][]byte) error {
+	forms, err := diskIdentifierForms(diskIdentifier)
+	if err != nil {
+		return err
+	}
+
+	identifiers := readIdentifiers(filepath.Base(canonicalDevicePath))
+	if len(identifiers) == 0 {
+		// Without identifiers, e.g. on old kernels, the SCSI address has to be trusted
+		klog.V(5).InfoS("Ignoring missing SCSI identifiers", "canonicalDevicePath", canonicalDevicePath, "diskIdentifier", diskIdentifier)
+		return nil
+	}
+	for _, identifier := range identifiers {
+		if matchesDiskIdentifier(identifier, forms) {
+			return nil
+		}
+	}
+	return fmt.Errorf("refusing to use %s because its SCSI identifiers do not contain disk identifier %s", canonicalDevicePath, diskIdentifier)
+}
+
+// diskIdentifierForms returns the hex strings a VHD disk identifier, a GUID, appears as in SCSI
+// identifiers: in the order it is written in, and in the mixed-endian order of its bytes.
+func diskIdentifierForms(diskIdentifier string) ([]string, error) {
+	id := strings.ToLower(strings.NewReplacer("-", "", "{", "", "}", "").Replace(diskIdentifier))
+	if _, err := hex.DecodeString(id); err != nil || len(id) != 32 {
+		return nil, fmt.Errorf("invalid disk identifier %q", diskIdentifier)
+	}
+
+	swapped := id[6:8] + id[4:6] + id[2:4] + id[0:2] + id[10:12] + id[8:10] + id[14:16] + id[12:14] + id[16:]
+	return []string{id, swapped}, nil
+}
+
+// matchesDiskIdentifier reports whether a SCSI identifier, as text or as binary VPD data, contains
+// one of the forms of a disk identifier.
+func matchesDiskIdentifier(identifier []byte, forms []string) bool {
+	text := strings.ToLower(strings.ReplaceAll(string(identifier), "-", ""))
+	binary := hex.EncodeToString(identifier)
+	for _, form := range forms {
+		if strings.Contains(text, form) || strings.Contains(binary, form) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,456 @@
+//go:build linux
+// +build linux
+
+package mounter
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	mountutils "k8s.io/mount-utils"
+	utilexec "k8s.io/utils/exec"
+	testingexec "k8s.io/utils/exec/testing"
+)
+
+func TestVerifyVolumeSerialMatch(t *testing.T) {
+	const diskIdentifier = "6A1C2B3D-4E5F-4A6B-8C7D-9E0F1A2B3C4D"
+	mixedEndian, _ := hex.DecodeString("3d2b1c6a5f4e6b4a8c7d9e0f1a2b3c4d")
+
+	testCases := []struct {
+		name        string
+		identifiers [][]byte
+		expectErr   bool
+	}{
+		{
+			name:        "success: VPD page with the identifier in mixed-endian byte order",
+			identifiers: [][]byte{append([]byte("\x00\x83\x00\x1c\x01\x01\x00\x18MSFT    "), mixedEndian...)},
+		},
+		{
+			name:        "success: WWID with the identifier as text",
+			identifiers: [][]byte{[]byte("t10.MSFT    6a1c2b3d4e5f4a6b8c7d9e0f1a2b3c4d\n")},
+		},
+		{
+			name: "success: no identifiers to check",
+		},
+		{
+			name:        "fail: identifiers of another disk",
+			identifiers: [][]byte{[]byte("t10.MSFT    00000000000000000000000000000000\n")},
+			expectErr:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			readIdentifiers := func(name string) [][]byte {
+				if name != "sdb" {
+					t.Errorf("expected identifiers of sdb, got %s", name)
+				}
+				return tc.identifiers
+			}
+
+			err := verifyVolumeSerialMatch("/dev/sdb", diskIdentifier, readIdentifiers)
+			if tc.expectErr && err == nil {
+				t.Fatal("expected an error, got nil")
+			}
+			if !tc.expectErr && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestDiskIdentifierForms(t *testing.T) {
+	forms, err := diskIdentifierForms("{6A1C2B3D-4E5F-4A6B-8C7D-9E0F1A2B3C4D}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"6a1c2b3d4e5f4a6b8c7d9e0f1a2b3c4d", "3d2b1c6a5f4e6b4a8c7d9e0f1a2b3c4d"}
+	for i := range expected {
+		if forms[i] != expected[i] {
+			t.Errorf("expected form %q, got %q", expected[i], forms[i])
+		}
+	}
+
+	if _, err := diskIdentifierForms("not-a-guid"); err == nil {
+		t.Error("expected an error for an invalid disk identifier, got nil")
+	}
+}
+
+func TestWaitForSCSIBlockDevice(t *testing.T) {
+	savedDeviceBackoff, savedLinkBackoff := scsiDeviceBackoff, diskByIDLinkBackoff
+	t.Cleanup(func() {
+		scsiDeviceBackoff, diskByIDLinkBackoff = savedDeviceBackoff, savedLinkBackoff
+	})
+	scsiDeviceBackoff = wait.Backoff{Duration: time.Millisecond, Factor: 1, Steps: 3}
+	diskByIDLinkBackoff = wait.Backoff{Duration: time.Millisecond, Factor: 1, Steps: 3}
+
+	testCases := []struct {
+		name           string
+		scsiDevices    map[string]string
+		byIDLinks      map[string]string
+		noUdev         bool
+		expectedDevice string
+		expectErr      bool
+	}{
+		{
+			name:           "success: device with a by-id link",
+			scsiDevices:    map[string]string{"0:0:0:1": "sdb", "0:0:0:2": "sdc"},
+			byIDLinks:      map[string]string{"scsi-360022480e5ba0ad8a1e1ea0a7b8b0c3d": "sdb"},
+			expectedDevice: "sdb",
+		},
+		{
+			name:           "success: device without a by-id link",
+			scsiDevices:    map[string]string{"0:0:0:1": "sdb"},
+			expectedDevice: "sdb",
+		},
+		{
+			name:           "success: device on a node without udev",
+			scsiDevices:    map[string]string{"0:0:0:1": "sdb"},
+			noUdev:         true,
+			expectedDevice: "sdb",
+		},
+		{
+			name:        "fail: device on another host",
+			scsiDevices: map[string]string{"1:0:0:1": "sdb"},
+			expectErr:   true,
+		},
+		{
+			name:      "fail: no devices",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			m := &NodeMounter{sysfsRoot: filepath.Join(root, "sys"), devRoot: root}
+
+			mkdir(t, filepath.Join(m.sysfsRoot, classSCSIHostPath, "host0"))
+			mkdir(t, filepath.Join(m.sysfsRoot, classSCSIDevicePath))
+			if !tc.noUdev {
+				mkdir(t, filepath.Join(root, diskByIDPath))
+			}
+			for address, name := range tc.scsiDevices {
+				mkdir(t, filepath.Join(m.sysfsRoot, classSCSIDevicePath, address, classSCSIBlockDevicePath, name))
+				writeFile(t, filepath.Join(root, devicePath, name), "")
+			}
+			for link, name := range tc.byIDLinks {
+				if err := os.Symlink(filepath.Join(root, devicePath, name), filepath.Join(root, diskByIDPath, link)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			device, err := m.WaitForSCSIBlockDevice(0, 1)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("expected an error, got device %s", device)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if expected := filepath.Join(root, devicePath, tc.expectedDevice); device != expected {
+				t.Errorf("expected device %s, got %s", expected, device)
+			}
+
+			scan, err := os.ReadFile(filepath.Join(m.sysfsRoot, classSCSIHostPath, "host0", scsiHostScanPath))
+			if err != nil {
+				t.Fatalf("expected SCSI host 0 to be rescanned: %v", err)
+			}
+			if string(scan) != "- - 1" {
+				t.Errorf("expected scan of LUN 1, got %q", scan)
+			}
+		})
+	}
+}
+
+func TestWaitForBlockDeviceByDiskIdentifier(t *testing.T) {
+	savedDeviceBackoff, savedLinkBackoff := scsiDeviceBackoff, diskByIDLinkBackoff
+	t.Cleanup(func() {
+		scsiDeviceBackoff, diskByIDLinkBackoff = savedDeviceBackoff, savedLinkBackoff
+	})
+	scsiDeviceBackoff = wait.Backoff{Duration: time.Millisecond, Factor: 1, Steps: 3}
+	diskByIDLinkBackoff = wait.Backoff{Duration: time.Millisecond, Factor: 1, Steps: 3}
+
+	const (
+		diskIdentifier = "6A1C2B3D-4E5F-4A6B-8C7D-9E0F1A2B3C4D"
+		wwid           = "t10.MSFT    6a1c2b3d4e5f4a6b8c7d9e0f1a2b3c4d\n"
+	)
+
+	testCases := []struct {
+		name           string
+		wwids          map[string]string
+		noDeviceNode   bool
+		expectedDevice string
+		expectErr      error
+	}{
+		{
+			name:           "success: device found by its identifier",
+			wwids:          map[string]string{"sdc": wwid},
+			expectedDevice: "sdc",
+		},
+		{
+			name:      "fail: device never shows up",
+			expectErr: ErrDiskNotFound,
+		},
+		{
+			name:         "fail: device has no device node",
+			wwids:        map[string]string{"sdc": wwid},
+			noDeviceNode: true,
+			expectErr:    ErrDiskNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			m := &NodeMounter{sysfsRoot: filepath.Join(root, "sys"), devRoot: root}
+
+			// Only the hosts of Hyper-V SCSI controllers are rescanned, whatever their numbers
+			mkdir(t, filepath.Join(m.sysfsRoot, classSCSIHostPath, "host0"))
+			writeFile(t, filepath.Join(m.sysfsRoot, classSCSIHostPath, "host0", scsiHostProcNamePath), "ata_piix\n")
+			mkdir(t, filepath.Join(m.sysfsRoot, classSCSIHostPath, "host3"))
+			writeFile(t, filepath.Join(m.sysfsRoot, classSCSIHostPath, "host3", scsiHostProcNamePath), storvscDriverName+"\n")
+			mkdir(t, filepath.Join(m.sysfsRoot, classBlockPath))
+			for name, id := range tc.wwids {
+				mkdir(t, filepath.Join(m.sysfsRoot, classBlockPath, name, "device"))
+				writeFile(t, filepath.Join(m.sysfsRoot, classBlockPath, name, scsiWWIDPath), id)
+				if !tc.noDeviceNode {
+					writeFile(t, filepath.Join(root, devicePath, name), "")
+				}
+			}
+
+			lun := 2
+			device, err := m.WaitForBlockDeviceByDiskIdentifier(diskIdentifier, &lun)
+			if scan, err := os.ReadFile(filepath.Join(m.sysfsRoot, classSCSIHostPath, "host3", scsiHostScanPath)); err != nil || string(scan) != "- - 2" {
+				t.Errorf("expected scan of LUN 2 on SCSI host 3, got %q, %v", scan, err)
+			}
+			if _, err := os.Stat(filepath.Join(m.sysfsRoot, classSCSIHostPath, "host0", scsiHostScanPath)); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("expected SCSI host 0 not to be rescanned, got %v", err)
+			}
+
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected error %v, got %v, device %s", tc.expectErr, err, device)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if expected := filepath.Join(root, devicePath, tc.expectedDevice); device != expected {
+				t.Errorf("expected device %s, got %s", expected, device)
+			}
+		})
+	}
+}
+
+func TestGetBlockDevicePathByDiskIdentifier(t *testing.T) {
+	const (
+		diskIdentifier = "6A1C2B3D-4E5F-4A6B-8C7D-9E0F1A2B3C4D"
+		wwid           = "t10.MSFT    6a1c2b3d4e5f4a6b8c7d9e0f1a2b3c4d\n"
+		otherWWID      = "t10.MSFT    00000000000000000000000000000000\n"
+	)
+
+	testCases := []struct {
+		name           string
+		wwids          map[string]string
+		byIDLinks      map[string]string
+		expectedDevice string
+		expectErr      error
+	}{
+		{
+			name:           "success: device found by its WWID in sysfs",
+			wwids:          map[string]string{"sdb": otherWWID, "sdc": wwid},
+			expectedDevice: "sdc",
+		},
+		{
+			name:           "success: device found by its by-id link",
+			wwids:          map[string]string{"sdb": otherWWID, "sdc": ""},
+			byIDLinks:      map[string]string{"scsi-16a1c2b3d4e5f4a6b8c7d9e0f1a2b3c4d": "sdc"},
+			expectedDevice: "sdc",
+		},
+		{
+			name:      "fail: no device has the identifier",
+			wwids:     map[string]string{"sdb": otherWWID},
+			expectErr: ErrDiskNotFound,
+		},
+		{
+			name:      "fail: several devices have the identifier",
+			wwids:     map[string]string{"sdb": wwid, "sdc": wwid},
+			expectErr: ErrDiskNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			m := &NodeMounter{sysfsRoot: filepath.Join(root, "sys"), devRoot: root}
+
+			mkdir(t, filepath.Join(root, diskByIDPath))
+			for name, id := range tc.wwids {
+				mkdir(t, filepath.Join(m.sysfsRoot, classBlockPath, name, "device"))
+				if id != "" {
+					writeFile(t, filepath.Join(m.sysfsRoot, classBlockPath, name, scsiWWIDPath), id)
+				}
+				writeFile(t, filepath.Join(root, devicePath, name), "")
+			}
+			for link, name := range tc.byIDLinks {
+				if err := os.Symlink(filepath.Join(root, devicePath, name), filepath.Join(root, diskByIDPath, link)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			device, err := m.GetBlockDevicePathByDiskIdentifier(diskIdentifier)
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected error %v, got %v, device %s", tc.expectErr, err, device)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if expected := filepath.Join(root, devicePath, tc.expectedDevice); device != expected {
+				t.Errorf("expected device %s, got %s", expected, device)
+			}
+		})
+	}
+}
+
+func TestDeleteSCSIDevice(t *testing.T) {
+	testCases := []struct {
+		name          string
+		device        string
+		mounted       bool
+		holder        bool
+		gone          bool
+		expectDeleted bool
+		expectErr     error
+	}{
+		{
+			name:          "success: disk",
+			device:        "sdb",
+			expectDeleted: true,
+		},
+		{
+			name:          "success: disk of a partition",
+			device:        "sdb1",
+			expectDeleted: true,
+		},
+		{
+			name:   "success: disk is already gone",
+			device: "sdb",
+			gone:   true,
+		},
+		{
+			name:      "fail: partition is still mounted",
+			device:    "sdb",
+			mounted:   true,
+			expectErr: ErrDeviceInUse,
+		},
+		{
+			name:      "fail: disk is held",
+			device:    "sdb",
+			holder:    true,
+			expectErr: ErrDeviceInUse,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			sysfsRoot := filepath.Join(root, "sys")
+
+			// sysfs lists partitions below their disk
+			diskPath := filepath.Join(sysfsRoot, "devices", "sdb")
+			writeFile(t, filepath.Join(diskPath, scsiDeviceDeletePath), "")
+			writeFile(t, filepath.Join(diskPath, "sdb1", blockDevicePartitionPath), "1")
+			mkdir(t, filepath.Join(sysfsRoot, classBlockPath))
+			for name, target := range map[string]string{"sdb": diskPath, "sdb1": filepath.Join(diskPath, "sdb1")} {
+				if err := os.Symlink(target, filepath.Join(sysfsRoot, classBlockPath, name)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tc.holder {
+				mkdir(t, filepath.Join(diskPath, blockDeviceHoldersPath, "dm-0"))
+			}
+			for _, name := range []string{"sdb", "sdb1"} {
+				writeFile(t, filepath.Join(root, devicePath, name), "")
+			}
+			if tc.gone {
+				if err := os.RemoveAll(filepath.Join(root, devicePath)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var mountPoints []mountutils.MountPoint
+			if tc.mounted {
+				mountPoints = append(mountPoints, mountutils.MountPoint{Device: filepath.Join(root, devicePath, "sdb1"), Path: "/mnt/other"})
+			}
+			var flushed bool
+			fakeExec := &testingexec.FakeExec{
+				CommandScript: []testingexec.FakeCommandAction{
+					func(cmd string, args ...string) utilexec.Cmd {
+						flushed = cmd == "blockdev" && args[0] == "--flushbufs" && args[1] == filepath.Join(root, devicePath, "sdb")
+						return &testingexec.FakeCmd{
+							CombinedOutputScript: []testingexec.FakeAction{
+								func() ([]byte, []byte, error) { return nil, nil, nil },
+							},
+						}
+					},
+				},
+			}
+			m := &NodeMounter{
+				SafeFormatAndMount: &mountutils.SafeFormatAndMount{
+					Interface: mountutils.NewFakeMounter(mountPoints),
+					Exec:      fakeExec,
+				},
+				sysfsRoot: sysfsRoot,
+				devRoot:   root,
+			}
+
+			err := m.DeleteSCSIDevice(filepath.Join(root, devicePath, tc.device))
+			if tc.expectErr != nil {
+				if !errors.Is(err, tc.expectErr) {
+					t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			deleted, err := os.ReadFile(filepath.Join(diskPath, scsiDeviceDeletePath))
+			if tc.expectDeleted {
+				if err != nil || string(deleted) != "1" {
+					t.Errorf("expected SCSI device sdb to be deleted, got %q, %v", deleted, err)
+				}
+				if !flushed {
+					t.Error("expected buffers of sdb to be flushed")
+				}
+			} else if len(deleted) > 0 {
+				t.Error("expected SCSI device sdb not to be deleted")
+			}
+		})
+	}
+}
+
+func mkdir(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	mkdir(t, filepath.Dir(path))
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
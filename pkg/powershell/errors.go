@@ -0,0 +1,19 @@
+package powershell
+
+import "fmt"
+
+// ScriptError is returned when a script exits with a non-zero code or writes to stderr.
+type ScriptError struct {
+	Operation string
+	ExitCode  int
+	Stdout    string
+	Stderr    string
+}
+
+func (e *ScriptError) Error() string {
+	if e.ExitCode != 0 {
+		return fmt.Sprintf("%s operation returned code=%d\nstderr:\n%s\nstdOut:\n%s", e.Operation, e.ExitCode, e.Stderr, e.Stdout)
+	}
+
+	return fmt.Sprintf("%s operation returned \nstderr:\n%s\nstdOut:\n%s", e.Operation, e.Stderr, e.Stdout)
+}
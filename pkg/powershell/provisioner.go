@@ -606,12 +606,13 @@ func RunPowershell(client *winrm.Client, elevatedUser string, elevatedPassword s
 		return 0, "", "", err
 	}
 
-	if commandExitCode != 0 {
-		return 0, "", "", fmt.Errorf("run command operation returned code=%d\nstderr:\n%s\nstdOut:\n%s", commandExitCode, errorOutPut, stdOutPut)
-	}
-
-	if len(errorOutPut) > 0 {
-		return 0, "", "", fmt.Errorf("run command operation returned \nstderr:\n%s\nstdOut:\n%s", errorOutPut, stdOutPut)
+	if commandExitCode != 0 || len(errorOutPut) > 0 {
+		return 0, "", "", &ScriptError{
+			Operation: "run command",
+			ExitCode:  commandExitCode,
+			Stdout:    stdOutPut,
+			Stderr:    errorOutPut,
+		}
 	}
 
 	err = DeleteFileOrDirectory(client, path)
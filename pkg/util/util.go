@@ -205,6 +205,36 @@ func JoinWinPath(elem ...string) string {
 	return ""
 }
 
+// DirWinPath returns all but the last element of the Windows path.
+func DirWinPath(path string) string {
+	i := strings.LastIndex(path, "\\")
+	if i < 0 {
+		return ""
+	}
+
+	return path[:i]
+}
+
+// BaseWinPath returns the last element of the Windows path.
+func BaseWinPath(path string) string {
+	return path[strings.LastIndex(path, "\\")+1:]
+}
+
+// IsWinPathWithin reports whether the Windows path is inside the directory root. Paths with
+// empty, "." or ".." elements, forward slashes or alternate data streams are never inside root.
+func IsWinPathWithin(path, root string) bool {
+	root = strings.TrimRight(root, "\\")
+	if len(path) <= len(root)+1 || !strings.EqualFold(path[:len(root)], root) || path[len(root)] != '\\' {
+		return false
+	}
+	for _, elem := range strings.Split(path[len(root)+1:], "\\") {
+		if elem == "" || elem == "." || elem == ".." || strings.ContainsAny(elem, "/:") {
+			return false
+		}
+	}
+	return true
+}
+
 // SerializeData is helper function to serialize data
 func SerializeData[T any](msg *T) ([]byte, error) {
 	buf := new(bytes.Buffer)
@@ -0,0 +1,52 @@
+package winrm
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNotFound is returned when the script could not find an object it works on.
+	ErrNotFound = errors.New("object not found")
+
+	// ErrAlreadyExists is returned when the script tried to create an object that already exists.
+	ErrAlreadyExists = errors.New("object already exists")
+
+	// ErrInUse is returned when an object is locked by another process or virtual machine.
+	ErrInUse = errors.New("object in use")
+
+	// ErrInvalidParameter is returned when the script rejected one of its parameters.
+	ErrInvalidParameter = errors.New("invalid parameter")
+
+	// ErrAccessDenied is returned when the remote user is not allowed to perform the operation.
+	ErrAccessDenied = errors.New("access denied")
+
+	// ErrTimeout is returned when the script or the WinRM connection timed out.
+	ErrTimeout = errors.New("operation timed out")
+)
+
+// ScriptError represents the PowerShell error record of a failed script.
+type ScriptError struct {
+	// Kind is one of the errors of this package, nil when the record could not be classified
+	Kind                  error
+	Message               string
+	Category              string
+	FullyQualifiedErrorID string
+	Err                   error
+}
+
+func (e *ScriptError) Error() string {
+	if e.Message == "" {
+		return e.Err.Error()
+	}
+
+	return fmt.Sprintf("%s (%s, %s)", e.Message, e.Category, e.FullyQualifiedErrorID)
+}
+
+func (e *ScriptError) Unwrap() []error {
+	if e.Kind == nil {
+		return []error{e.Err}
+	}
+
+	return []error{e.Kind, e.Err}
+}
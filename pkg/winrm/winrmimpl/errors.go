@@ -0,0 +1,157 @@
+package winrmimpl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/nhduc2001kt/hyperv-csi-driver/pkg/powershell"
+	iwinrm "github.com/nhduc2001kt/hyperv-csi-driver/pkg/winrm"
+	"k8s.io/klog/v2"
+)
+
+// errorRecordPrefix marks the line a failed script writes its error record to.
+const errorRecordPrefix = "HYPERV-CSI-ERROR-RECORD:"
+
+// errorRecordHandler catches the error that stops a script and writes its record as JSON, so that
+// errors are classified by their fields instead of their formatted text.
+const errorRecordHandler = `
+catch {
+  $errorRecord = @{
+    Message               = $_.Exception.Message;
+    Category              = $_.CategoryInfo.Category.ToString();
+    FullyQualifiedErrorId = $_.FullyQualifiedErrorId;
+    ExceptionType         = $_.Exception.GetType().FullName;
+    HResult               = $_.Exception.HResult;
+  }
+  [Console]::Error.WriteLine('` + errorRecordPrefix + `' + (ConvertTo-Json -InputObject $errorRecord -Compress))
+  exit 1
+}
+`
+
+// errorRecord is the JSON form of a PowerShell ErrorRecord written by errorRecordHandler.
+type errorRecord struct {
+	Message               string
+	Category              string
+	FullyQualifiedErrorId string
+	ExceptionType         string
+	HResult               int32
+}
+
+var (
+	// errorCategories maps the PowerShell ErrorCategory of a record to an error kind.
+	errorCategories = map[string]error{
+		"ObjectNotFound":      iwinrm.ErrNotFound,
+		"ResourceExists":      iwinrm.ErrAlreadyExists,
+		"ResourceBusy":        iwinrm.ErrInUse,
+		"InvalidArgument":     iwinrm.ErrInvalidParameter,
+		"InvalidData":         iwinrm.ErrInvalidParameter,
+		"InvalidType":         iwinrm.ErrInvalidParameter,
+		"PermissionDenied":    iwinrm.ErrAccessDenied,
+		"SecurityError":       iwinrm.ErrAccessDenied,
+		"OperationTimeout":    iwinrm.ErrTimeout,
+		"AuthenticationError": iwinrm.ErrAccessDenied,
+	}
+
+	// errorIDs classifies records whose category is too generic, e.g. InvalidOperation, by the
+	// error ID part of their FullyQualifiedErrorId.
+	errorIDs = map[string]error{
+		"PathNotFound":     iwinrm.ErrNotFound,
+		"ObjectNotFound":   iwinrm.ErrNotFound,
+		"ObjectInUse":      iwinrm.ErrInUse,
+		"InvalidParameter": iwinrm.ErrInvalidParameter,
+		"AccessDenied":     iwinrm.ErrAccessDenied,
+		"OperationTimeout": iwinrm.ErrTimeout,
+	}
+
+	// errorHResults classifies the records of Win32 errors, e.g. of file operations, by the HRESULT
+	// of their exception.
+	errorHResults = map[uint32]error{
+		0x80070002: iwinrm.ErrNotFound,      // ERROR_FILE_NOT_FOUND
+		0x80070003: iwinrm.ErrNotFound,      // ERROR_PATH_NOT_FOUND
+		0x80070005: iwinrm.ErrAccessDenied,  // ERROR_ACCESS_DENIED
+		0x80070020: iwinrm.ErrInUse,         // ERROR_SHARING_VIOLATION
+		0x80070021: iwinrm.ErrInUse,         // ERROR_LOCK_VIOLATION
+		0x80070050: iwinrm.ErrAlreadyExists, // ERROR_FILE_EXISTS
+		0x800700B7: iwinrm.ErrAlreadyExists, // ERROR_ALREADY_EXISTS
+		0x800705B4: iwinrm.ErrTimeout,       // ERROR_TIMEOUT
+	}
+)
+
+// wrapScript makes a script write the record of the error that stops it, see errorRecordHandler.
+func wrapScript(script string) string {
+	return "try {\n" + script + "\n}" + errorRecordHandler
+}
+
+// translateError turns the errors of a script run into the errors of the winrm package.
+func translateError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return fmt.Errorf("%w: %w", iwinrm.ErrTimeout, err)
+	}
+
+	var scriptErr *powershell.ScriptError
+	if !errors.As(err, &scriptErr) {
+		return err
+	}
+
+	result := &iwinrm.ScriptError{Err: err}
+	// Elevated scripts write their error stream to stdout
+	record, ok := findErrorRecord(scriptErr.Stderr)
+	if !ok {
+		record, ok = findErrorRecord(scriptErr.Stdout)
+	}
+	if !ok {
+		klog.V(4).InfoS("translateError: script failed without an error record", "err", err)
+		return result
+	}
+
+	result.Message = record.Message
+	result.Category = record.Category
+	result.FullyQualifiedErrorID = record.FullyQualifiedErrorId
+	result.Kind = classifyErrorRecord(record)
+
+	klog.V(4).InfoS("translateError: script failed", "kind", result.Kind, "category", result.Category, "errorID", result.FullyQualifiedErrorID, "exceptionType", record.ExceptionType, "hresult", fmt.Sprintf("0x%08X", uint32(record.HResult)), "err", err)
+	return result
+}
+
+// findErrorRecord returns the last error record written to the output of a script.
+func findErrorRecord(output string) (errorRecord, bool) {
+	var record errorRecord
+	found := false
+	for _, line := range strings.Split(output, "\n") {
+		_, recordJson, ok := strings.Cut(strings.TrimSpace(line), errorRecordPrefix)
+		if !ok {
+			continue
+		}
+		if err := json.Unmarshal([]byte(recordJson), &record); err != nil {
+			klog.V(4).InfoS("findErrorRecord: invalid error record", "record", recordJson, "err", err)
+			continue
+		}
+		found = true
+	}
+	return record, found
+}
+
+// classifyErrorRecord returns the error kind of a record by its category, its error ID and the
+// HRESULT of its exception, in this order, or nil if none of them is known.
+func classifyErrorRecord(record errorRecord) error {
+	if kind, ok := errorCategories[record.Category]; ok {
+		return kind
+	}
+
+	// FullyQualifiedErrorId is <error ID>,<command>
+	errorID, _, _ := strings.Cut(record.FullyQualifiedErrorId, ",")
+	if kind, ok := errorIDs[errorID]; ok {
+		return kind
+	}
+
+	return errorHResults[uint32(record.HResult)]
+}
@@ -0,0 +1,125 @@
+package winrmimpl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/nhduc2001kt/hyperv-csi-driver/pkg/powershell"
+	iwinrm "github.com/nhduc2001kt/hyperv-csi-driver/pkg/winrm"
+)
+
+func TestTranslateError(t *testing.T) {
+	record := func(category, errorID string, hresult uint32) string {
+		return fmt.Sprintf(`%s{"Message":"failed","Category":"%s","FullyQualifiedErrorId":"%s","ExceptionType":"System.Exception","HResult":%d}`,
+			errorRecordPrefix, category, errorID, int32(hresult))
+	}
+
+	testCases := []struct {
+		name             string
+		err              error
+		expectedKind     error
+		expectedCategory string
+		expectedErrorID  string
+	}{
+		{
+			name:             "not found by category",
+			err:              &powershell.ScriptError{ExitCode: 1, Stderr: record("ObjectNotFound", "ObjectNotFound,Microsoft.Vhd.PowerShell.Cmdlets.GetVHD", 0x80131500)},
+			expectedKind:     iwinrm.ErrNotFound,
+			expectedCategory: "ObjectNotFound",
+			expectedErrorID:  "ObjectNotFound,Microsoft.Vhd.PowerShell.Cmdlets.GetVHD",
+		},
+		{
+			name:             "in use by category",
+			err:              &powershell.ScriptError{ExitCode: 1, Stderr: record("ResourceBusy", "Microsoft.PowerShell.Commands.WriteErrorException", 0x80131500)},
+			expectedKind:     iwinrm.ErrInUse,
+			expectedCategory: "ResourceBusy",
+			expectedErrorID:  "Microsoft.PowerShell.Commands.WriteErrorException",
+		},
+		{
+			name:             "invalid parameter by error ID",
+			err:              &powershell.ScriptError{ExitCode: 1, Stderr: record("InvalidOperation", "InvalidParameter,Microsoft.HyperV.PowerShell.Commands.AddVMHardDiskDrive", 0x80131500)},
+			expectedKind:     iwinrm.ErrInvalidParameter,
+			expectedCategory: "InvalidOperation",
+			expectedErrorID:  "InvalidParameter,Microsoft.HyperV.PowerShell.Commands.AddVMHardDiskDrive",
+		},
+		{
+			name:             "in use by HRESULT",
+			err:              &powershell.ScriptError{ExitCode: 1, Stderr: record("WriteError", "CopyFileInfoItemIOError,Microsoft.PowerShell.Commands.CopyItemCommand", 0x80070020)},
+			expectedKind:     iwinrm.ErrInUse,
+			expectedCategory: "WriteError",
+			expectedErrorID:  "CopyFileInfoItemIOError,Microsoft.PowerShell.Commands.CopyItemCommand",
+		},
+		{
+			name:             "access denied by HRESULT",
+			err:              &powershell.ScriptError{ExitCode: 1, Stderr: record("NotSpecified", "System.UnauthorizedAccessException", 0x80070005)},
+			expectedKind:     iwinrm.ErrAccessDenied,
+			expectedCategory: "NotSpecified",
+			expectedErrorID:  "System.UnauthorizedAccessException",
+		},
+		{
+			name:             "record written to stdout by an elevated script",
+			err:              &powershell.ScriptError{ExitCode: 1, Stdout: "output\r\n" + record("ResourceExists", "ResourceExists", 0) + "\r\n"},
+			expectedKind:     iwinrm.ErrAlreadyExists,
+			expectedCategory: "ResourceExists",
+			expectedErrorID:  "ResourceExists",
+		},
+		{
+			name:             "unknown record",
+			err:              &powershell.ScriptError{ExitCode: 1, Stderr: record("OperationStopped", "VHD Size must be specified", 0x80131501)},
+			expectedCategory: "OperationStopped",
+			expectedErrorID:  "VHD Size must be specified",
+		},
+		{
+			name: "no record",
+			err:  &powershell.ScriptError{ExitCode: 1, Stderr: "The term 'Get-VHD' is not recognized, the object cannot be found"},
+		},
+		{
+			name:         "deadline exceeded",
+			err:          fmt.Errorf("run script: %w", context.DeadlineExceeded),
+			expectedKind: iwinrm.ErrTimeout,
+		},
+	}
+
+	kinds := []error{iwinrm.ErrNotFound, iwinrm.ErrAlreadyExists, iwinrm.ErrInUse, iwinrm.ErrInvalidParameter, iwinrm.ErrAccessDenied, iwinrm.ErrTimeout}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := translateError(tc.err)
+			if !errors.Is(err, tc.err) {
+				t.Errorf("expected the error to wrap %v, got %v", tc.err, err)
+			}
+			for _, kind := range kinds {
+				if errors.Is(err, kind) != (kind == tc.expectedKind) {
+					t.Errorf("expected kind %v, got %v", tc.expectedKind, err)
+				}
+			}
+
+			var scriptErr *iwinrm.ScriptError
+			if !errors.As(err, &scriptErr) {
+				if tc.expectedCategory != "" {
+					t.Fatalf("expected a script error, got %v", err)
+				}
+				return
+			}
+			if scriptErr.Category != tc.expectedCategory {
+				t.Errorf("expected category %q, got %q", tc.expectedCategory, scriptErr.Category)
+			}
+			if scriptErr.FullyQualifiedErrorID != tc.expectedErrorID {
+				t.Errorf("expected error ID %q, got %q", tc.expectedErrorID, scriptErr.FullyQualifiedErrorID)
+			}
+		})
+	}
+}
+
+func TestWrapScript(t *testing.T) {
+	script := wrapScript("$ErrorActionPreference = 'Stop'\nGet-VHD -Path 'C:\\pvc-1.vhdx'")
+	if !strings.HasPrefix(script, "try {\n$ErrorActionPreference = 'Stop'\nGet-VHD") {
+		t.Errorf("expected the script to run in a try block, got %q", script)
+	}
+	if !strings.Contains(script, "catch {") || !strings.Contains(script, errorRecordPrefix) {
+		t.Errorf("expected the script to write its error record, got %q", script)
+	}
+}
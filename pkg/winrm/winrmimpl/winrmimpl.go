@@ -166,7 +166,7 @@ func (c *winrmClient) RunFireAndForgetScript(ctx context.Context, script *templa
 	if err != nil {
 		return err
 	}
-	command := scriptRendered.String()
+	command := wrapScript(scriptRendered.String())
 
 	winrmClient, err := c.winRmClientPool.BorrowObject(ctx)
 	if err != nil {
@@ -178,7 +178,7 @@ func (c *winrmClient) RunFireAndForgetScript(ctx context.Context, script *templa
 	klog.V(4).InfoS("ReturnObject: called")
 	errRet := c.winRmClientPool.ReturnObject(ctx, winrmClient)
 	if err != nil {
-		return err
+		return translateError(err)
 	}
 	if errRet != nil {
 		return errRet
@@ -195,7 +195,7 @@ func (c *winrmClient) RunScriptWithResult(ctx context.Context, script *template.
 		return err
 	}
 
-	command := scriptRendered.String()
+	command := wrapScript(scriptRendered.String())
 
 	winrmClient, err := c.winRmClientPool.BorrowObject(ctx)
 
@@ -208,7 +208,7 @@ func (c *winrmClient) RunScriptWithResult(ctx context.Context, script *template.
 	err2 := c.winRmClientPool.ReturnObject(ctx, winrmClient)
 
 	if err != nil {
-		return err
+		return translateError(err)
 	}
 
 	if err2 != nil {